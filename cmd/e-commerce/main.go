@@ -1,51 +1,291 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	. "github.com/Phantomvv1/E-commerce/internal/audit"
 	. "github.com/Phantomvv1/E-commerce/internal/authentication"
 	. "github.com/Phantomvv1/E-commerce/internal/cart"
+	. "github.com/Phantomvv1/E-commerce/internal/categories"
 	. "github.com/Phantomvv1/E-commerce/internal/comparison"
+	. "github.com/Phantomvv1/E-commerce/internal/database"
 	. "github.com/Phantomvv1/E-commerce/internal/emails"
 	. "github.com/Phantomvv1/E-commerce/internal/items"
+	. "github.com/Phantomvv1/E-commerce/internal/media"
+	. "github.com/Phantomvv1/E-commerce/internal/privacy"
 	. "github.com/Phantomvv1/E-commerce/internal/wishlist"
 	"github.com/gin-gonic/gin"
 )
 
+func createAdmin(args []string) {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	name := flags.String("name", "", "name of the admin")
+	email := flags.String("email", "", "email of the admin")
+	password := flags.String("password", "", "password of the admin, only needed if the account doesn't exist yet")
+	flags.Parse(args)
+
+	if *email == "" {
+		log.Fatal("Error the email of the admin is required")
+	}
+
+	pool, err := NewPool(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pool.Close()
+
+	if err = NewAuthRepository(pool).CreateAdmin(context.Background(), *name, *email, *password); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println(*email, "is now an admin")
+}
+
+// migrate runs "migrate up" (the default), "migrate down [steps]" or
+// "migrate status".
+func migrate(args []string) {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	pool, err := NewPool(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pool.Close()
+
+	switch command {
+	case "up":
+		applied, err := Migrate(context.Background(), pool)
+		for _, migration := range applied {
+			log.Printf("applied %04d_%s", migration.Version, migration.Name)
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(applied) == 0 {
+			log.Println("the schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatal("Error the number of steps has to be a positive number")
+			}
+		}
+
+		rolledBack, err := Rollback(context.Background(), pool, steps)
+		for _, migration := range rolledBack {
+			log.Printf("rolled back %04d_%s", migration.Version, migration.Name)
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		migrations, err := MigrationStatus(context.Background(), pool)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, migration := range migrations {
+			status := "pending"
+			if migration.AppliedAt != nil {
+				status = "applied at " + migration.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%04d_%s\t%s\n", migration.Version, migration.Name, status)
+		}
+	default:
+		log.Fatal("Error unknown migrate command, use up, down or status")
+	}
+}
+
 func main() {
+	if err := LoadPasswordHasher(); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "create-admin" {
+		createAdmin(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
+	if err := LoadKeyRing(); err != nil {
+		log.Fatal(err)
+	}
+
+	pool, err := NewPool(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pool.Close()
+
+	if err = CheckSchema(context.Background(), pool); err != nil {
+		log.Fatal(err)
+	}
+
+	accounts := NewAuthRepository(pool)
+	if err = accounts.GrantAdminPermissions(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = accounts.BootstrapAdmin(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	storage, err := NewStorage()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auth := NewAuthHandler(pool)
+	items := NewItemHandler(pool, storage)
+	categories := NewCategoryHandler(pool)
+	cart := NewCartHandler(pool)
+	wishlist := NewWishlistHandler(pool)
+	comparison := NewComparisonHandler(pool)
+	emails := NewEmailHandler(pool)
+	privacy := NewPrivacyHandler(pool)
+	audit := NewAuditHandler(pool)
+
 	r := gin.Default()
+	if err = TrustProxies(r); err != nil {
+		log.Fatal(err)
+	}
 
 	r.Any("/", func(c *gin.Context) { c.JSON(http.StatusOK, nil) })
-	r.POST("/signup", SignUp)
-	r.POST("/login", LogIn)
-	r.POST("/profile", GetCurrentProfile)
-	r.POST("/profiles", GetAllUsers)
-	r.POST("/item", CreateItem)
-	r.PUT("/item", UpdateItem)
-	r.POST("/item/get", GetItemByID)
-	r.POST("/item/search", SearchForItem)
-	r.GET("/items", GetAllItems)
-	r.GET("/item/rand", GetRandomItem)
-	r.DELETE("/item", DeleteItem)
-	r.GET("/item/count", CountItems)
-	r.POST("/cart/item", AddItemToCart)
-	r.POST("/cart/items", GetItemsFromCart)
-	r.DELETE("/cart/item", RemoveItemFromCart)
-	r.POST("/cart/item/count", CountItemsInCart)
-	r.POST("/cart/pay", Checkout)
-	r.DELETE("/cart/all", RemoveEverythingFromCart)
-	r.POST("/cart/price", GetCartPrice)
-	r.POST("/wishlist", PutItemInWishlist)
-	r.POST("/wishlist/item", GetItemFromWishlist)
-	r.POST("/wishlist/items", GetAllItemsFromWishlist)
-	r.DELETE("/wishlist/item", RemoveItemFromWishlist)
-	r.POST("/coupon", ApplyCoupon)
-	r.DELETE("/coupon", RemoveCoupon)
-	r.POST("/compare/item", AddItemToCompare)
-	r.POST("/compare", Compare)
-	r.DELETE("/compare/item", RemoveItemFromComparison)
-	r.DELETE("/compare/items", RemoveAllItemsFromComparison)
-	r.POST("email", SendEmail)
+	if local, ok := storage.(*LocalStorage); ok && strings.HasPrefix(local.URL, "/") {
+		r.Static(local.URL, local.Dir)
+	}
+
+	r.POST("/signup", auth.SignUp)
+	r.POST("/login", auth.LogIn)
+	r.POST("/login/2fa", auth.LogInTwoFactor)
+	r.POST("/login/2fa/setup", auth.SetUpTwoFactorAtLogIn)
+	r.POST("/login/link", auth.SendLoginLink)
+	r.POST("/login/link/verify", auth.LogInWithLink)
+	r.GET("/login/link/verify", auth.LogInWithLink)
+	r.GET("/oauth/:provider", auth.StartOAuthLogIn)
+	r.GET("/oauth/:provider/callback", auth.OAuthCallback)
+	r.POST("/token/refresh", auth.RefreshToken)
+	r.GET("/.well-known/jwks.json", GetJWKS)
+	r.GET("/email/verify", auth.VerifyEmail)
+	r.POST("/password/forgot", auth.ForgotPassword)
+	r.POST("/password/reset", auth.ResetPassword)
+	r.POST("/item/get", items.GetItemByID)
+	r.POST("/item/search", items.SearchForItem)
+	r.GET("/items", items.GetAllItems)
+	r.GET("/item/rand", items.GetRandomItem)
+	r.GET("/item/count", items.CountItems)
+	r.GET("/categories", categories.GetCategories)
+	r.GET("/category/:slug", categories.GetCategory)
+	r.POST("/payments/webhook", cart.PaymentWebhook)
+
+	authenticated := r.Group("/", auth.Authenticate)
+	account := authenticated.Group("/", RequireUser)
+	account.POST("/logout", auth.LogOut)
+	account.GET("/profile", RequireOwner, auth.GetCurrentProfile)
+	account.PUT("/profile", auth.UpdateProfile)
+	account.DELETE("/profile", auth.DeleteAccount)
+	account.GET("/profile/export", RequireOwner, privacy.ExportPersonalData)
+	account.POST("/password/change", auth.ChangePassword)
+	account.GET("/sessions", RequireOwner, auth.GetSessions)
+	account.DELETE("/session", auth.DeleteSession)
+	account.DELETE("/sessions", auth.DeleteOtherSessions)
+	account.POST("/2fa/setup", auth.SetUpTwoFactor)
+	account.POST("/2fa/enable", auth.EnableTwoFactor)
+	account.POST("/2fa/disable", auth.DisableTwoFactor)
+	account.POST("/2fa/recovery-codes", auth.RegenerateRecoveryCodes)
+	account.POST("/cart/item", cart.AddItemToCart)
+	account.GET("/cart/items", cart.GetItemsFromCart)
+	account.DELETE("/cart/item", cart.RemoveItemFromCart)
+	account.GET("/cart/item/count", cart.CountItemsInCart)
+	account.POST("/cart/pay", cart.Checkout)
+	account.DELETE("/cart/all", cart.RemoveEverythingFromCart)
+	account.GET("/cart/price", cart.GetCartPrice)
+	account.POST("/wishlist", wishlist.PutItemInWishlist)
+	account.GET("/wishlist/item/:id", wishlist.GetItemFromWishlist)
+	account.GET("/wishlist/items", wishlist.GetAllItemsFromWishlist)
+	account.DELETE("/wishlist/item", wishlist.RemoveItemFromWishlist)
+	account.POST("/coupon", cart.ApplyCoupon)
+	account.DELETE("/coupon", cart.RemoveCoupon)
+	account.POST("/compare/item", comparison.AddItemToCompare)
+	account.GET("/compare", comparison.Compare)
+	account.DELETE("/compare/item", comparison.RemoveItemFromComparison)
+	account.DELETE("/compare/items", comparison.RemoveAllItemsFromComparison)
+	account.POST("/email", emails.SendEmail)
+	account.POST("/email/verify/resend", auth.ResendVerificationEmail)
+
+	authenticated.GET("/profiles", RequirePermission(PermissionUsersRead), auth.GetAllUsers)
+	authenticated.GET("/admin/cart/:id", RequirePermission(PermissionCartsRead), cart.GetCartOfUser)
+	authenticated.GET("/admin/user/:id/export", RequirePermission(PermissionUsersRead), privacy.ExportUserData)
+	authenticated.GET("/admin/audit", RequirePermission(PermissionAuditRead), audit.GetAuditLog)
+	account.POST("/admin/impersonate", RequirePermission(PermissionUsersImpersonate), auth.ImpersonateUser)
+
+	catalog := authenticated.Group("/", RequirePermission(PermissionItemsWrite))
+	catalog.POST("/item", items.CreateItem)
+	catalog.PUT("/item", items.UpdateItem)
+	catalog.DELETE("/item", items.DeleteItem)
+	catalog.PUT("/item/options", items.SetItemOptions)
+	catalog.POST("/item/variant", items.CreateVariant)
+	catalog.PUT("/item/variant", items.UpdateVariant)
+	catalog.DELETE("/item/variant", items.DeleteVariant)
+	catalog.POST("/item/image", items.UploadItemImage)
+	catalog.PUT("/item/images", items.OrderItemImages)
+	catalog.DELETE("/item/image", items.DeleteItemImage)
+	catalog.PUT("/item/categories", categories.SetItemCategories)
+	catalog.POST("/category", categories.CreateCategory)
+	catalog.PUT("/category", categories.UpdateCategory)
+	catalog.DELETE("/category", categories.DeleteCategory)
+
+	inventory := authenticated.Group("/inventory", RequirePermission(PermissionInventoryWrite))
+	inventory.POST("/stock", items.AdjustStock)
+	inventory.GET("/variant/:id/movements", items.GetStockMovements)
+	inventory.GET("/variant/:id/stock", items.GetVariantStock)
+	inventory.POST("/transfer", items.TransferStock)
+	inventory.POST("/allocation", items.AllocateStock)
+	inventory.GET("/warehouses", items.GetWarehouses)
+	inventory.POST("/warehouse", items.CreateWarehouse)
+	inventory.PUT("/warehouse", items.UpdateWarehouse)
+	inventory.DELETE("/warehouse", items.DeleteWarehouse)
+
+	users := account.Group("/admin", RequirePermission(PermissionUsersManage))
+	users.POST("/logout", auth.ForceLogOut)
+	users.POST("/promote", auth.PromoteUser)
+	users.POST("/demote", auth.DemoteUser)
+	users.POST("/role", auth.SetUserRole)
+	users.POST("/unlock", auth.UnlockAccount)
+	users.POST("/suspend", auth.SuspendUser)
+	users.POST("/unsuspend", auth.UnsuspendUser)
+
+	roles := account.Group("/roles", RequirePermission(PermissionRolesManage))
+	roles.GET("", auth.GetRoles)
+	roles.POST("", auth.CreateRole)
+	roles.PUT("", auth.UpdateRolePermissions)
+	roles.DELETE("", auth.DeleteRole)
+
+	apiKeys := account.Group("/apikeys", RequirePermission(PermissionAPIKeysManage))
+	apiKeys.GET("", auth.GetAPIKeys)
+	apiKeys.POST("", auth.CreateAPIKey)
+	apiKeys.DELETE("", auth.RevokeAPIKey)
 
 	r.Run(":42069")
 }
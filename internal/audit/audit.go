@@ -0,0 +1,248 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// Execer is implemented by both the pool and pgx.Tx, so an entry can be
+// written in the same transaction as the change it describes.
+type Execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	ActorID    *int            `json:"actorID"`
+	APIKeyID   *int            `json:"apiKeyID"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"targetType"`
+	TargetID   *string         `json:"targetID"`
+	Changes    json.RawMessage `json:"changes"`
+	IP         string          `json:"ip"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+func snapshot(value interface{}) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	if value == nil || reflect.ValueOf(value).Kind() == reflect.Pointer && reflect.ValueOf(value).IsNil() {
+		return result, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// diff keeps only the fields that differ between the two snapshots, each with
+// its value before and after the change.
+func diff(before, after interface{}) (map[string]interface{}, error) {
+	old, err := snapshot(before)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := snapshot(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]interface{}{}
+	for key, value := range old {
+		if !reflect.DeepEqual(value, updated[key]) {
+			changes[key] = gin.H{"before": value, "after": updated[key]}
+		}
+	}
+
+	for key, value := range updated {
+		if _, ok := old[key]; !ok {
+			changes[key] = gin.H{"before": nil, "after": value}
+		}
+	}
+
+	return changes, nil
+}
+
+// Record appends an entry for an action done by the user or API key making
+// the request. Before and after are snapshots of the target, either of them
+// can be nil when it's created or deleted.
+//
+// It's called in the transaction of the change with the transaction as db,
+// so a change which can't be recorded is rolled back and the request fails.
+func Record(c *gin.Context, db Execer, action, targetType string, targetID, before, after interface{}) error {
+	return RecordAs(c, db, c.GetInt("id"), action, targetType, targetID, before, after)
+}
+
+// RecordAs is Record for requests that aren't authenticated yet, like logins,
+// where the actor is known from the request itself.
+func RecordAs(c *gin.Context, db Execer, actorID int, action, targetType string, targetID, before, after interface{}) error {
+	ctx := c.Request.Context()
+	changes, err := diff(before, after)
+	if err != nil {
+		return err
+	}
+
+	var actor, apiKey *int
+	if actorID != 0 {
+		actor = &actorID
+	}
+
+	if id := c.GetInt("apiKeyID"); id != 0 {
+		apiKey = &id
+	}
+
+	var target *string
+	if targetID != nil {
+		value := fmt.Sprint(targetID)
+		target = &value
+	}
+
+	_, err = db.Exec(ctx, "insert into e_commerce.audit_log (actor_id, api_key_id, action, target_type, target_id, changes, ip) values ($1, $2, $3, $4, $5, $6, $7)",
+		actor, apiKey, action, targetType, target, changes, c.ClientIP())
+	return err
+}
+
+type AuditHandler struct {
+	db database.DB
+}
+
+func NewAuditHandler(db database.DB) *AuditHandler {
+	return &AuditHandler{db: db}
+}
+
+// GetAuditLog returns a page of the entries matching the filters from the
+// query: actorID, apiKeyID, action, targetType, targetID, from and to.
+func (h *AuditHandler) GetAuditLog(c *gin.Context) {
+	conditions := []string{"true"}
+	args := []interface{}{}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	for _, filter := range []struct {
+		Name      string
+		Condition string
+	}{{"actorID", "actor_id = $%d"}, {"apiKeyID", "api_key_id = $%d"}} {
+		value := c.Query(filter.Name)
+		if value == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided " + filter.Name})
+			return
+		}
+
+		addCondition(filter.Condition, id)
+	}
+
+	if action := c.Query("action"); action != "" {
+		addCondition("action = $%d", action)
+	}
+
+	if targetType := c.Query("targetType"); targetType != "" {
+		addCondition("target_type = $%d", targetType)
+	}
+
+	if targetID := c.Query("targetID"); targetID != "" {
+		addCondition("target_id = $%d", targetID)
+	}
+
+	for _, filter := range []struct {
+		Name      string
+		Condition string
+	}{{"from", "created_at >= $%d"}, {"to", "created_at < $%d"}} {
+		value := c.Query(filter.Name)
+		if value == "" {
+			continue
+		}
+
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			date, err = time.Parse(time.DateOnly, value)
+		}
+
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided " + filter.Name + ", use YYYY-MM-DD or RFC 3339"})
+			return
+		}
+
+		addCondition(filter.Condition, date)
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided page"})
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", strconv.Itoa(defaultPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided pageSize"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	where := strings.Join(conditions, " and ")
+
+	total := 0
+	err = h.db.QueryRow(ctx, "select count(*) from e_commerce.audit_log where "+where, args...).Scan(&total)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := h.db.Query(ctx, fmt.Sprintf("select id, actor_id, api_key_id, action, target_type, target_id, changes, ip, created_at "+
+		"from e_commerce.audit_log where %s order by id desc limit $%d offset $%d", where, len(args)-1, len(args)), args...)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		entry := AuditEntry{}
+		err = rows.Scan(&entry.ID, &entry.ActorID, &entry.APIKeyID, &entry.Action, &entry.TargetType, &entry.TargetID, &entry.Changes, &entry.IP, &entry.CreatedAt)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error working with the information from the database"})
+			return
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if rows.Err() != nil {
+		log.Println(rows.Err())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error working with the information from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "page": page, "pageSize": pageSize, "total": total})
+}
@@ -0,0 +1,273 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+var emailPattern = regexp.MustCompile(".*@.*\\..*")
+
+// Tables holding rows of a user which are removed together with the account.
+var userDataTables = []string{
+	"e_commerce.cart",
+	"e_commerce.wishlist",
+	"e_commerce.comparison",
+	"e_commerce.refresh_tokens",
+	"e_commerce.sessions",
+	"e_commerce.email_verifications",
+	"e_commerce.password_resets",
+	"e_commerce.login_links",
+	"e_commerce.oauth_identities",
+	"e_commerce.two_factor",
+	"e_commerce.recovery_codes",
+	"e_commerce.two_factor_challenges",
+	"e_commerce.coupons",
+	"e_commerce.points_history",
+	"e_commerce.impersonations",
+	"e_commerce.stock_reservations",
+}
+
+func (r *AuthRepository) setName(ctx context.Context, id int, name string) error {
+	_, err := r.db.Exec(ctx, "update e_commerce.authentication set name = $1 where id = $2", name, id)
+	return err
+}
+
+// setPendingEmail stores the email the account is being changed to until it's
+// verified.
+func (r *AuthRepository) setPendingEmail(ctx context.Context, id int, email string) error {
+	_, err := r.db.Exec(ctx, "update e_commerce.authentication set pending_email = $1 where id = $2", email, id)
+	return err
+}
+
+func (r *AuthRepository) AnonymizeAccount(ctx context.Context, userID int) error {
+	return r.inTx(ctx, func(tx *AuthRepository) error {
+		for _, table := range userDataTables {
+			_, err := tx.db.Exec(ctx, "delete from "+table+" where user_id = $1", userID)
+			if err != nil {
+				return err
+			}
+		}
+
+		var email string
+		var pendingEmail *string
+		err := tx.db.QueryRow(ctx, "select email, pending_email from e_commerce.authentication where id = $1", userID).Scan(&email, &pendingEmail)
+		if err != nil {
+			return err
+		}
+
+		emails, pseudonyms := []string{email}, []string{emailPseudonym(email)}
+		if pendingEmail != nil {
+			emails, pseudonyms = append(emails, *pendingEmail), append(pseudonyms, emailPseudonym(*pendingEmail))
+		}
+
+		if _, err = tx.db.Exec(ctx, "delete from e_commerce.sent_emails where recipient = any($1)", emails); err != nil {
+			return err
+		}
+
+		if _, err = tx.db.Exec(ctx, "delete from e_commerce.login_attempts where email = any($1)", pseudonyms); err != nil {
+			return err
+		}
+
+		_, err = tx.db.Exec(ctx, "update e_commerce.authentication set name = 'Deleted user', email = $1, password = '', points = 0, verified = false, "+
+			"pending_email = null, deleted_at = now(), tokens_revoked_at = $3 where id = $2", fmt.Sprintf("deleted-%d@deleted.invalid", userID), userID, time.Now())
+		return err
+	})
+}
+
+func (h *AuthHandler) UpdateProfile(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // name || email
+
+	id := CurrentUserID(c)
+
+	name, updateName := information["name"]
+	email, updateEmail := information["email"]
+	if !updateName && !updateEmail {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error not enough information to update the profile with"})
+		return
+	}
+
+	if updateEmail && !emailPattern.MatchString(email) {
+		log.Println("Invalid email")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error invalid email"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	updateEmail = updateEmail && email != CurrentEmail(c)
+	if !updateName && !updateEmail {
+		c.JSON(http.StatusOK, nil)
+		return
+	}
+
+	if updateEmail {
+		taken, err := h.users.emailTaken(ctx, email, id)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+			return
+		}
+
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "Error there is already a person with this email"})
+			return
+		}
+	}
+
+	// The audit log outlives the account, so it only keeps what was changed
+	// and not the name or the email.
+	changed := []string{}
+	err := h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if updateName {
+			if err := tx.setName(ctx, id, name); err != nil {
+				return err
+			}
+
+			changed = append(changed, "name")
+		}
+
+		// The new email only replaces the old one after it's verified.
+		if updateEmail {
+			if err := tx.setPendingEmail(ctx, id, email); err != nil {
+				return err
+			}
+
+			changed = append(changed, "pendingEmail")
+		}
+
+		return audit.Record(c, tx.db, "auth.profile_update", "user", id, nil, gin.H{"changed": changed})
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	if !updateEmail {
+		c.JSON(http.StatusOK, nil)
+		return
+	}
+
+	if err = h.sendVerificationEmail(ctx, id, email); err != nil {
+		if err == ErrTooManyVerificationEmails {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to send the verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "A verification link was sent to the new email"})
+}
+
+// reauthenticated checks that the user of an account without a password, one
+// made with OAuth, has just proven who they are. That is a two-factor code if
+// they use two-factor authentication and otherwise a recent login. When they
+// haven't, it responds to the request.
+func (h *AuthHandler) reauthenticated(c *gin.Context, id int, information map[string]string) bool {
+	ctx := c.Request.Context()
+	enabled, err := h.users.TwoFactorEnabled(ctx, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return false
+	}
+
+	if enabled {
+		if err = h.users.checkSecondFactor(ctx, id, information); err != nil {
+			if err == ErrInvalidTwoFactorCode || err == ErrTwoFactorNotSetUp {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return false
+			}
+
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to check the two-factor code"})
+			return false
+		}
+
+		return true
+	}
+
+	startedAt, err := h.users.sessionStartedAt(ctx, id, CurrentSessionID(c))
+	if err != nil && err != pgx.ErrNoRows {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return false
+	}
+
+	if err == pgx.ErrNoRows || time.Since(startedAt) > RecentLogInDuration {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error log in again to confirm that it's you"})
+		return false
+	}
+
+	return true
+}
+
+// DeleteAccount removes the data of the user and anonymizes the account row,
+// which is kept so that the coupons and other records referencing it stay valid.
+// Accounts without a password are confirmed with reauthenticated instead.
+func (h *AuthHandler) DeleteAccount(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // password || code || recoveryCode
+
+	id := CurrentUserID(c)
+
+	ctx := c.Request.Context()
+	passwordCheck, err := h.users.passwordHash(ctx, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if passwordCheck == "" {
+		if !h.reauthenticated(c, id, information) {
+			return
+		}
+	} else {
+		password, ok := information["password"]
+		if !ok {
+			log.Println("Incorrectly provided password")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided password"})
+			return
+		}
+
+		match, _, err := VerifyPassword(password, passwordCheck)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to check your password"})
+			return
+		}
+
+		if !match {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Error wrong password"})
+			return
+		}
+	}
+
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.AnonymizeAccount(ctx, id); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "auth.account_delete", "user", id, nil, nil)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to delete your account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
@@ -0,0 +1,242 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateAdmin makes the account with the given email an admin, creating it
+// first if nobody is registered with that email.
+func (r *AuthRepository) CreateAdmin(ctx context.Context, name, email, password string) error {
+	id := 0
+	var accountType byte
+	err := r.db.QueryRow(ctx, "select id, type from e_commerce.authentication where email = $1", email).Scan(&id, &accountType)
+	if err != nil && err != pgx.ErrNoRows {
+		return err
+	}
+
+	if err == pgx.ErrNoRows {
+		if password == "" {
+			return errors.New("Error a password is needed to create the admin account")
+		}
+
+		hashedPassword, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+
+		return r.inTx(ctx, func(tx *AuthRepository) error {
+			err := tx.db.QueryRow(ctx, "insert into e_commerce.authentication (name, email, password, type, points, verified) values ($1, $2, $3, $4, 0, true) returning id",
+				name, email, hashedPassword, Admin).Scan(&id)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.db.Exec(ctx, "insert into e_commerce.role_changes (user_id, old_type, new_type) values ($1, null, $2)", id, Admin)
+			return err
+		})
+	}
+
+	if accountType == Admin {
+		return nil
+	}
+
+	return r.changeAccountType(ctx, id, 0, accountType, Admin)
+}
+
+// BootstrapAdmin creates the first admin from ADMIN_EMAIL, ADMIN_PASSWORD and
+// ADMIN_NAME when they are set and there are no admins yet.
+func (r *AuthRepository) BootstrapAdmin(ctx context.Context) error {
+	email := os.Getenv("ADMIN_EMAIL")
+	if email == "" {
+		return nil
+	}
+
+	admins, err := r.countAccounts(ctx, Admin)
+	if err != nil {
+		return err
+	}
+
+	if admins > 0 {
+		return nil
+	}
+
+	log.Println("Creating the first admin account for", email)
+	return r.CreateAdmin(ctx, os.Getenv("ADMIN_NAME"), email, os.Getenv("ADMIN_PASSWORD"))
+}
+
+func (r *AuthRepository) countAccounts(ctx context.Context, accountType int) (int, error) {
+	count := 0
+	err := r.db.QueryRow(ctx, "select count(*) from e_commerce.authentication where type = $1", accountType).Scan(&count)
+	return count, err
+}
+
+func (r *AuthRepository) accountType(ctx context.Context, userID int) (byte, error) {
+	var accountType byte
+	err := r.db.QueryRow(ctx, "select type from e_commerce.authentication where id = $1", userID).Scan(&accountType)
+	return accountType, err
+}
+
+func (r *AuthRepository) changeAccountType(ctx context.Context, userID, changedBy int, oldType, newType byte) error {
+	var changer interface{}
+	if changedBy != 0 {
+		changer = changedBy
+	}
+
+	err := r.inTx(ctx, func(tx *AuthRepository) error {
+		_, err := tx.db.Exec(ctx, "update e_commerce.authentication set type = $1 where id = $2", newType, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.db.Exec(ctx, "insert into e_commerce.role_changes (user_id, changed_by, old_type, new_type) values ($1, $2, $3, $4)",
+			userID, changer, oldType, newType)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// The type of the account is part of its tokens, so the old ones have to go.
+	return r.RevokeAllTokens(ctx, userID)
+}
+
+func (h *AuthHandler) setAccountType(c *gin.Context, userID int, newType byte) {
+	adminID := CurrentUserID(c)
+
+	ctx := c.Request.Context()
+	exists, err := h.users.roleExists(ctx, int(newType))
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no role with this id"})
+		return
+	}
+
+	oldType, err := h.users.accountType(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no user with this id"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if oldType == newType {
+		c.JSON(http.StatusConflict, gin.H{"error": "Error the account already has this type"})
+		return
+	}
+
+	if (oldType == Admin || newType == Admin) && CurrentAccountType(c) != Admin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error only an admin can make someone an admin or change the role of one"})
+		return
+	}
+
+	if !h.outranks(c, oldType) {
+		return
+	}
+
+	permissions, err := h.users.RolePermissions(ctx, newType)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if !holdsPermissions(c, permissions) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error you can't give a role with a permission you don't have"})
+		return
+	}
+
+	if oldType == Admin {
+		admins, err := h.users.countAccounts(ctx, Admin)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+			return
+		}
+
+		if admins <= 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Error unable to demote the last admin"})
+			return
+		}
+	}
+
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.changeAccountType(ctx, userID, adminID, oldType, newType); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "admin.role_change", "user", userID, gin.H{"type": oldType}, gin.H{"type": newType})
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to change the type of the account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func userIDFromBody(c *gin.Context) (int, map[string]interface{}, bool) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // userID
+
+	userIDFl, ok := information["userID"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the user")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the user"})
+		return 0, nil, false
+	}
+
+	return int(userIDFl), information, true
+}
+
+func (h *AuthHandler) PromoteUser(c *gin.Context) {
+	userID, _, ok := userIDFromBody(c)
+	if !ok {
+		return
+	}
+
+	h.setAccountType(c, userID, Admin)
+}
+
+func (h *AuthHandler) DemoteUser(c *gin.Context) {
+	userID, _, ok := userIDFromBody(c)
+	if !ok {
+		return
+	}
+
+	h.setAccountType(c, userID, User)
+}
+
+func (h *AuthHandler) SetUserRole(c *gin.Context) {
+	userID, information, ok := userIDFromBody(c) // && roleID
+	if !ok {
+		return
+	}
+
+	roleID, ok := information["roleID"].(float64)
+	if !ok || roleID < 1 || roleID > 255 {
+		log.Println("Incorrectly provided id of the role")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the role"})
+		return
+	}
+
+	h.setAccountType(c, userID, byte(roleID))
+}
@@ -0,0 +1,216 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyPrefix starts every API key, so the auth layer can tell them apart
+// from JWTs.
+const APIKeyPrefix = "ek_"
+
+type APIKey struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Permissions []string   `json:"permissions"`
+	CreatedBy   int        `json:"createdBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt"`
+	RevokedAt   *time.Time `json:"revokedAt"`
+}
+
+// ValidateAPIKey returns the id and the permissions of a key which isn't
+// revoked or expired and marks it as used. A key only acts for the account
+// which created it, so it stops working once that account is suspended or
+// deleted and keeps only the permissions the account still has.
+func (r *AuthRepository) ValidateAPIKey(ctx context.Context, key string) (int, []string, error) {
+	id := 0
+	permissions := []string{}
+	err := r.db.QueryRow(ctx, "update e_commerce.api_keys k set last_used_at = now() from e_commerce.authentication a "+
+		"where k.key_hash = $1 and k.revoked_at is null and (k.expires_at is null or k.expires_at > now()) "+
+		"and a.id = k.created_by and a.suspended_at is null and a.deleted_at is null "+
+		"returning k.id, array(select p from unnest(k.permissions) p where p in (select permission from e_commerce.role_permissions where role_id = a.type))",
+		hashToken(key)).Scan(&id, &permissions)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return id, permissions, nil
+}
+
+func (r *AuthRepository) APIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := r.db.Query(ctx, "select id, name, prefix, permissions, created_by, created_at, expires_at, last_used_at, revoked_at "+
+		"from e_commerce.api_keys order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key := APIKey{}
+		err = rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.Permissions, &key.CreatedBy, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// AddAPIKey stores the hash of the key and sets the id of the new key.
+func (r *AuthRepository) AddAPIKey(ctx context.Context, key *APIKey, keyHash string) error {
+	return r.db.QueryRow(ctx, "insert into e_commerce.api_keys (name, prefix, key_hash, permissions, created_by, expires_at) values ($1, $2, $3, $4, $5, $6) returning id",
+		key.Name, key.Prefix, keyHash, key.Permissions, key.CreatedBy, key.ExpiresAt).Scan(&key.ID)
+}
+
+// RevokeAPIKey reports whether there was an active key with this id.
+func (r *AuthRepository) RevokeAPIKey(ctx context.Context, id int) (bool, error) {
+	result, err := r.db.Exec(ctx, "update e_commerce.api_keys set revoked_at = now() where id = $1 and revoked_at is null", id)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() != 0, nil
+}
+
+// RequireUser has to run after Authenticate and keeps API keys out of the
+// routes that act on the account of the caller.
+func RequireUser(c *gin.Context) {
+	if CurrentAPIKeyID(c) != 0 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Error this can't be done with an API key"})
+		return
+	}
+
+	c.Next()
+}
+
+func (h *AuthHandler) GetAPIKeys(c *gin.Context) {
+	ctx := c.Request.Context()
+	keys, err := h.users.APIKeys(ctx)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKeys": keys})
+}
+
+// CreateAPIKey mints a new key. The key can only get permissions the admin
+// creating it has and it's shown just this once, since only its hash is kept.
+func (h *AuthHandler) CreateAPIKey(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // name && permissions && expiresInDays?
+
+	name, ok := information["name"].(string)
+	if !ok || name == "" {
+		log.Println("Incorrectly provided name of the API key")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided name of the API key"})
+		return
+	}
+
+	permissions, ok := stringsFromJSON(information["permissions"])
+	if !ok || len(permissions) == 0 || !validPermissions(permissions) {
+		log.Println("Incorrectly provided permissions of the API key")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided permissions of the API key"})
+		return
+	}
+
+	for _, permission := range permissions {
+		if !slices.Contains(CurrentPermissions(c), permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Error you can't give an API key a permission you don't have"})
+			return
+		}
+	}
+
+	var expiresAt *time.Time
+	if days, ok := information["expiresInDays"].(float64); ok {
+		if days <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error the API key has to expire in at least one day"})
+			return
+		}
+
+		expiration := time.Now().Add(time.Hour * 24 * time.Duration(days))
+		expiresAt = &expiration
+	}
+
+	ctx := c.Request.Context()
+	secret, err := randomToken(32)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to create the API key"})
+		return
+	}
+
+	key := APIKeyPrefix + secret
+	apiKey := APIKey{Name: name, Prefix: key[:len(APIKeyPrefix)+8], Permissions: permissions, CreatedBy: CurrentUserID(c), ExpiresAt: expiresAt}
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.AddAPIKey(ctx, &apiKey, hashToken(key)); err != nil {
+			return err
+		}
+
+		after := gin.H{"name": name, "prefix": apiKey.Prefix, "permissions": permissions, "expiresAt": expiresAt}
+		return audit.Record(c, tx.db, "admin.apikey_create", "api_key", apiKey.ID, nil, after)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": apiKey.ID, "key": key})
+}
+
+func (h *AuthHandler) RevokeAPIKey(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id
+
+	idFl, ok := information["id"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the API key")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the API key"})
+		return
+	}
+	id := int(idFl)
+
+	ctx := c.Request.Context()
+	revoked := false
+	err := h.users.inTx(ctx, func(tx *AuthRepository) error {
+		var err error
+		revoked, err = tx.RevokeAPIKey(ctx, id)
+		if err != nil || !revoked {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "admin.apikey_revoke", "api_key", id, nil, nil)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no active API key with this id"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
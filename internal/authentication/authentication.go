@@ -8,10 +8,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Phantomvv1/E-commerce/internal/audit"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5"
@@ -23,84 +25,98 @@ const (
 )
 
 type Profile struct {
-	ID     int    `json:"id"`
-	Name   string `json:"name"`
-	Email  string `json:"email"`
-	Type   byte   `json:"type"`
-	Points int    `json:"points"`
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Type      byte      `json:"type"`
+	Points    int       `json:"points"`
+	Verified  bool      `json:"verified"`
+	Suspended bool      `json:"suspended"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
-func GenerateJWT(id int, accountType byte, email string) (string, error) {
-	claims := jwt.MapClaims{
-		"id":         id,
-		"type":       accountType,
-		"email":      email,
-		"expiration": time.Now().Add(time.Hour * 24).Unix(),
-	}
+// Claims are the claims of an access token. The id of the user is the
+// subject and the id of the token is kept in jti, so it can be revoked.
+type Claims struct {
+	Type        byte     `json:"type"`
+	Email       string   `json:"email"`
+	Permissions []string `json:"permissions"`
+	SessionID   int      `json:"sid"`
+	// Impersonator is the id of the staff member using a read-only token of
+	// this user.
+	Impersonator int `json:"impersonator,omitempty"`
+	jwt.RegisteredClaims
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	jwtKey := os.Getenv("JWT_KEY")
-	return token.SignedString([]byte(jwtKey))
+func (c *Claims) UserID() int {
+	id, _ := strconv.Atoi(c.Subject)
+	return id
 }
 
-func ValidateJWT(tokenString string) (int, byte, error) {
-	claims := &jwt.MapClaims{}
+func newClaims(id int, accountType byte, email string, permissions []string, duration time.Duration) (*Claims, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &Claims{
+		Type:        accountType,
+		Email:       email,
+		Permissions: permissions,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(id),
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+		},
+	}, nil
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.ErrUnsupported
-		}
+func GenerateJWT(id, sessionID int, accountType byte, email string, permissions []string) (string, error) {
+	claims, err := newClaims(id, accountType, email, permissions, AccessTokenDuration)
+	if err != nil {
+		return "", err
+	}
 
-		return []byte(os.Getenv("JWT_KEY")), nil
-	})
+	claims.SessionID = sessionID
+	return Keys.Sign(claims)
+}
+
+func (h *AuthHandler) ValidateJWT(ctx context.Context, tokenString, ip string) (*Claims, error) {
+	claims := &Claims{}
 
+	token, err := jwt.ParseWithClaims(tokenString, claims, Keys.VerificationKey, jwt.WithExpirationRequired(), jwt.WithIssuedAt())
 	if err != nil || !token.Valid {
-		return 0, 0, err
+		return nil, err
 	}
 
-	expiration, ok := (*claims)["expiration"].(float64)
-	if !ok {
-		return 0, 0, errors.New("Error parsing the expiration date of the token")
+	if _, err = strconv.Atoi(claims.Subject); err != nil {
+		return nil, errors.New("Error incorrect subject of the token")
 	}
 
-	if int64(expiration) < time.Now().Unix() {
-		return 0, 0, errors.New("Error token has expired")
+	if claims.ID == "" {
+		return nil, errors.New("Error the token has no id")
 	}
 
-	id, ok := (*claims)["id"].(float64)
-	if !ok {
-		return 0, 0, errors.New("Incorrect type of id")
+	if claims.IssuedAt == nil {
+		return nil, errors.New("Error the token has no issue date")
 	}
 
-	accountType, ok := (*claims)["type"].(float64)
-	if !ok {
-		return 0, 0, errors.New("Incorrect type of account")
+	revoked, err := h.users.TokenRevoked(ctx, claims.ID, claims.UserID(), claims.SessionID, float64(claims.IssuedAt.UnixMicro())/1e6)
+	if err != nil {
+		return nil, err
 	}
 
-	return int(id), byte(accountType), nil
-}
-
-func GetEmail(tokenString string) (string, error) {
-	claims := &jwt.MapClaims{}
-
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.ErrUnsupported
-		}
-
-		return []byte(os.Getenv("JWT_KEY")), nil
-	})
-
-	if err != nil || !token.Valid {
-		return "", err
+	if revoked {
+		return nil, errors.New("Error token has been revoked")
 	}
 
-	email, ok := (*claims)["email"].(string)
-	if !ok {
-		return "", errors.New("Error unable to parse the email of the person")
+	if err = h.users.TouchSession(ctx, claims.SessionID, ip); err != nil {
+		return nil, err
 	}
 
-	return email, nil
+	return claims, nil
 }
 
 func SHA512(text string) string {
@@ -110,28 +126,151 @@ func SHA512(text string) string {
 	return fmt.Sprintf("%x", result)
 }
 
-func CreateAuthTable(conn *pgx.Conn) error {
-	_, err := conn.Exec(context.Background(), "create table if not exists e_commerce.authentication (id serial primary key, name text, email text, password text, type int, points int)")
+// Exists reports whether there is an account with this id which isn't deleted.
+func (r *AuthRepository) Exists(ctx context.Context, id int) (bool, error) {
+	exists := false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from e_commerce.authentication where id = $1 and deleted_at is null)", id).Scan(&exists)
+	return exists, err
+}
+
+// emailTaken reports whether an account other than exceptID uses this email.
+func (r *AuthRepository) emailTaken(ctx context.Context, email string, exceptID int) (bool, error) {
+	taken := false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from e_commerce.authentication where email = $1 and id != $2)", email, exceptID).Scan(&taken)
+	return taken, err
+}
+
+// createUser creates an unverified account with no points.
+func (r *AuthRepository) createUser(ctx context.Context, name, email, passwordHash string, accountType byte) (int, error) {
+	id := 0
+	err := r.db.QueryRow(ctx, "insert into e_commerce.authentication (name, email, password, type, points, verified) values ($1, $2, $3, $4, 0, false) returning id",
+		name, email, passwordHash, accountType).Scan(&id)
+	return id, err
+}
+
+// credentials returns the account with this email together with the hash of
+// its password.
+func (r *AuthRepository) credentials(ctx context.Context, email string) (*Profile, string, error) {
+	profile := &Profile{}
+	passwordHash := ""
+	err := r.db.QueryRow(ctx, "select id, password, name, type, email from e_commerce.authentication a where a.email = $1 and a.deleted_at is null", email).
+		Scan(&profile.ID, &passwordHash, &profile.Name, &profile.Type, &profile.Email)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return profile, passwordHash, nil
+}
+
+func (r *AuthRepository) passwordHash(ctx context.Context, id int) (string, error) {
+	passwordHash := ""
+	err := r.db.QueryRow(ctx, "select password from e_commerce.authentication where id = $1", id).Scan(&passwordHash)
+	return passwordHash, err
+}
+
+// rehashPassword replaces the hash of the password without logging out the
+// user, unlike setPassword.
+func (r *AuthRepository) rehashPassword(ctx context.Context, id int, passwordHash string) error {
+	_, err := r.db.Exec(ctx, "update e_commerce.authentication set password = $1 where id = $2", passwordHash, id)
 	return err
 }
 
-func SignUp(c *gin.Context) {
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+func (r *AuthRepository) Profile(ctx context.Context, id int) (*Profile, error) {
+	profile := &Profile{ID: id}
+	err := r.db.QueryRow(ctx, "select name, email, type, points, verified, suspended_at is not null, created_at from e_commerce.authentication where id = $1", id).
+		Scan(&profile.Name, &profile.Email, &profile.Type, &profile.Points, &profile.Verified, &profile.Suspended, &profile.CreatedAt)
 	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Databse connection failed"})
-		return
+		return nil, err
 	}
 
-	var information map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&information) //name, email, password, type, company_name
+	return profile, nil
+}
 
-	if err = CreateAuthTable(conn); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating a tablew for authentication"})
-		return
+// UserFilter narrows down the users returned by Users. Empty fields don't
+// filter anything.
+type UserFilter struct {
+	Email         string
+	Name          string
+	Role          *int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Suspended     *bool
+}
+
+// Users returns a page of the accounts matching the filter, along with the
+// number of all of the matching accounts.
+func (r *AuthRepository) Users(ctx context.Context, filter UserFilter, page, pageSize int) ([]Profile, int, error) {
+	conditions := []string{"deleted_at is null"}
+	args := []interface{}{}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	likePattern := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+	if filter.Email != "" {
+		addCondition("email ilike $%d", "%"+likePattern.Replace(filter.Email)+"%")
+	}
+
+	if filter.Name != "" {
+		addCondition("name ilike $%d", "%"+likePattern.Replace(filter.Name)+"%")
+	}
+
+	if filter.Role != nil {
+		addCondition("type = $%d", *filter.Role)
+	}
+
+	if filter.CreatedAfter != nil {
+		addCondition("created_at >= $%d", *filter.CreatedAfter)
+	}
+
+	if filter.CreatedBefore != nil {
+		addCondition("created_at < $%d", *filter.CreatedBefore)
+	}
+
+	if filter.Suspended != nil {
+		if *filter.Suspended {
+			conditions = append(conditions, "suspended_at is not null")
+		} else {
+			conditions = append(conditions, "suspended_at is null")
+		}
+	}
+
+	where := strings.Join(conditions, " and ")
+
+	total := 0
+	err := r.db.QueryRow(ctx, "select count(*) from e_commerce.authentication where "+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, pageSize, (page-1)*pageSize)
+	rows, err := r.db.Query(ctx, fmt.Sprintf("select id, name, email, type, points, verified, suspended_at is not null, created_at "+
+		"from e_commerce.authentication where %s order by id limit $%d offset $%d", where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
 	}
+	defer rows.Close()
 
+	profiles := []Profile{}
+	for rows.Next() {
+		profile := Profile{}
+		err = rows.Scan(&profile.ID, &profile.Name, &profile.Email, &profile.Type, &profile.Points, &profile.Verified, &profile.Suspended, &profile.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, total, rows.Err()
+}
+
+func (h *AuthHandler) SignUp(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) //name, email, password
+
+	ctx := c.Request.Context()
 	validEmail, err := regexp.MatchString(".*@.*\\..*", information["email"])
 	if err != nil {
 		log.Println(err)
@@ -145,196 +284,188 @@ func SignUp(c *gin.Context) {
 		return
 	}
 
-	var check string
-	err = conn.QueryRow(context.Background(), "select email from e_commerce.authentication where email = $1", information["email"]).Scan(&check)
-	emailExists := true
+	emailExists, err := h.users.emailTaken(ctx, information["email"], 0)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			emailExists = false
-		} else {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting the password from the table"})
-			return
-		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting the password from the table"})
+		return
 	}
 
 	if emailExists {
-		log.Println(err)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "There is already a person with this email"})
 		return
 	}
 
-	accountTypeStr, ok := information["type"]
-	if !ok {
-		log.Println("Incorrectly provided type of account")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided type of account"})
+	hashedPassword, err := HashPassword(information["password"])
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to hash the password"})
 		return
 	}
 
-	accountType := 0
-	if accountTypeStr == "admin" {
-		accountType = Admin
-	} else {
-		accountType = User
-	}
-
-	hashedPassword := SHA512(information["password"])
-	_, err = conn.Exec(context.Background(), "insert into e_commerce.authentication (name, email, password, type, points) values ($1, $2, $3, $4, 0)",
-		information["name"], information["email"], hashedPassword, accountType)
+	id, err := h.users.createUser(ctx, information["name"], information["email"], hashedPassword, User)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error inserting the information into the database."})
 		return
 	}
 
+	if err = h.sendVerificationEmail(ctx, id, information["email"]); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error your account was created but the verification email couldn't be sent, log in to request a new one"})
+		return
+	}
+
 	c.JSON(http.StatusOK, nil)
 }
 
-func LogIn(c *gin.Context) {
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+func (h *AuthHandler) LogIn(c *gin.Context) {
+	ctx := c.Request.Context()
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) //email, password
+
+	blocked, err := h.users.LoginBlocked(ctx, information["email"], c.ClientIP())
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection failed"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
 		return
 	}
 
-	if err = CreateAuthTable(conn); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+	if blocked {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Error too many failed attempts to log in, try again later"})
 		return
 	}
 
-	var information map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&information) //email, password
+	user, passwordCheck, err := h.users.credentials(ctx, information["email"])
+	if err != nil && err != pgx.ErrNoRows {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
 
-	var passwordCheck, name, email string
-	var accoutType byte
-	var id int
-	err = conn.QueryRow(context.Background(), "select id, password, name, type, email from e_commerce.authentication a where a.email = $1;", information["email"]).Scan(
-		&id, &passwordCheck, &name, &accoutType, &email)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			log.Println(err)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "There isn't anybody registered with this email!"})
-			return
-		} else {
+	match := false
+	rehash := false
+	if err == pgx.ErrNoRows {
+		user = &Profile{}
+		checkDummyPassword(information["password"])
+	} else {
+		match, rehash, err = VerifyPassword(information["password"], passwordCheck)
+		if err != nil {
 			log.Println(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
 			return
 		}
 	}
 
-	if SHA512(information["password"]) != passwordCheck {
-		log.Println("Wrong password")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error wrong password"})
-		return
-	}
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.RecordLoginAttempt(ctx, information["email"], c.ClientIP(), match); err != nil {
+			return err
+		}
+
+		if match {
+			return nil
+		}
 
-	jwtToken, err := GenerateJWT(id, accoutType, email)
+		return audit.RecordAs(c, tx.db, user.ID, "auth.login_failed", "user", emailPseudonym(information["email"]), nil, nil)
+	})
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while generating your token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": jwtToken})
-}
-
-func GetCurrentProfile(c *gin.Context) {
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error couldn't connect to the database"})
+	if !match {
+		log.Println("Failed login for", information["email"])
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid email or password"})
 		return
 	}
-	defer conn.Close(context.Background())
 
-	var tokenString map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&tokenString)
+	if rehash {
+		hashedPassword, err := HashPassword(information["password"])
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to hash the password"})
+			return
+		}
 
-	id, accountType, err := ValidateJWT(tokenString["token"])
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error validating the token"})
-		return
+		if err = h.users.rehashPassword(ctx, user.ID, hashedPassword); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the password in the database"})
+			return
+		}
 	}
 
-	var name, email string
-	points := 0
-	err = conn.QueryRow(context.Background(), "select name, email, points from e_commerce.authentication where id = $1", id).Scan(&name, &email, &points)
+	h.completeLogIn(c, user.ID, user.Type, user.Email, "password")
+}
+
+func (h *AuthHandler) GetCurrentProfile(c *gin.Context) {
+	profile, err := h.users.Profile(c.Request.Context(), CurrentUserID(c))
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting information from the database"})
 		return
 	}
 
-	userProfile := Profile{
-		ID:     id,
-		Name:   name,
-		Email:  email,
-		Points: points,
-		Type:   accountType,
-	}
+	profile.Type = CurrentAccountType(c)
 
-	c.JSON(http.StatusOK, gin.H{"profile": userProfile})
+	c.JSON(http.StatusOK, gin.H{"profile": profile})
 }
 
-func GetAllUsers(c *gin.Context) {
-	var information map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&information) // token
+// GetAllUsers returns a page of the users matching the filters from the query:
+// email, name, role, createdAfter, createdBefore and suspended.
+func (h *AuthHandler) GetAllUsers(c *gin.Context) {
+	filter := UserFilter{Email: c.Query("email"), Name: c.Query("name")}
 
-	token, ok := information["token"]
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
+	if role := c.Query("role"); role != "" {
+		roleID, err := strconv.Atoi(role)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided role"})
+			return
+		}
+
+		filter.Role = &roleID
 	}
 
-	_, accountType, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
-		return
+	for _, date := range []struct {
+		Name  string
+		Field **time.Time
+	}{{"createdAfter", &filter.CreatedAfter}, {"createdBefore", &filter.CreatedBefore}} {
+		value := c.Query(date.Name)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := parseDate(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided " + date.Name + ", use YYYY-MM-DD or RFC 3339"})
+			return
+		}
+
+		*date.Field = &parsed
 	}
 
-	if accountType != Admin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Error only admins can view all of the accounts"})
-		return
+	switch c.Query("suspended") {
+	case "true":
+		suspended := true
+		filter.Suspended = &suspended
+	case "false":
+		suspended := false
+		filter.Suspended = &suspended
 	}
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
+	page, pageSize, ok := pagination(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided page or pageSize"})
 		return
 	}
-	defer conn.Close(context.Background())
 
-	rows, err := conn.Query(context.Background(), "select id, name, email, type, points from e_commerce.authentication")
+	ctx := c.Request.Context()
+	profiles, total, err := h.users.Users(ctx, filter, page, pageSize)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error couldn't get information from the database"})
 		return
 	}
 
-	var profiles []Profile
-	for rows.Next() {
-		profile := Profile{}
-		err = rows.Scan(&profile.ID, &profile.Name, &profile.Email, &profile.Type, &profile.Points)
-		if err != nil {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error working with the data from the database"})
-			return
-		}
-
-		profiles = append(profiles, profile)
-	}
-
-	if rows.Err() != nil {
-		log.Println(rows.Err())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error working with the data from the database"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"profiles": profiles})
+	c.JSON(http.StatusOK, gin.H{"profiles": profiles, "page": page, "pageSize": pageSize, "total": total})
 }
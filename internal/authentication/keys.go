@@ -0,0 +1,261 @@
+package authentication
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func init() {
+	// Revocation compares the issue date of a token with the moment the
+	// tokens of the user were revoked, so whole seconds aren't precise enough.
+	jwt.TimePrecision = time.Microsecond
+}
+
+// SigningKey is a key the tokens are signed or verified with. Private is nil
+// for keys which were rotated out and are only kept to verify the tokens that
+// are still valid. For HMAC both of them hold the secret.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// KeyRing holds every key a token might have been signed with, looked up by
+// the kid header, and the one new tokens are signed with.
+type KeyRing struct {
+	signing *SigningKey
+	keys    map[string]*SigningKey
+	list    []*SigningKey
+}
+
+// Keys is the key ring used for the access tokens. It's empty until
+// LoadKeyRing is called.
+var Keys = &KeyRing{keys: map[string]*SigningKey{}}
+
+type keyEntry struct {
+	ID    string
+	Value string
+}
+
+// parseKeyList parses lists like "kid1:value1,kid2:value2".
+func parseKeyList(list string) ([]keyEntry, error) {
+	entries := []keyEntry{}
+	if list == "" {
+		return entries, nil
+	}
+
+	for _, item := range strings.Split(list, ",") {
+		id, value, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || id == "" || value == "" {
+			return nil, errors.New("Error keys have to be listed as kid:value separated by commas")
+		}
+
+		entries = append(entries, keyEntry{ID: id, Value: value})
+	}
+
+	return entries, nil
+}
+
+func (k *KeyRing) add(key *SigningKey) error {
+	if _, ok := k.keys[key.ID]; ok {
+		return errors.New("Error there is more than one key with the id " + key.ID)
+	}
+
+	k.keys[key.ID] = key
+	k.list = append(k.list, key)
+	return nil
+}
+
+func readPrivateKey(method jwt.SigningMethod, path string) (interface{}, interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if method == jwt.SigningMethodRS256 {
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return key, &key.PublicKey, nil
+	}
+
+	key, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, key.(ed25519.PrivateKey).Public(), nil
+}
+
+func readPublicKey(method jwt.SigningMethod, path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if method == jwt.SigningMethodRS256 {
+		return jwt.ParseRSAPublicKeyFromPEM(data)
+	}
+
+	return jwt.ParseEdPublicKeyFromPEM(data)
+}
+
+// keyRingFromEnv builds the key ring from the environment. HMAC secrets are
+// listed in JWT_KEYS (or the single JWT_KEY, which gets the id "default").
+// With JWT_ALGORITHM set to EdDSA or RS256, the PEM files of the private keys
+// are listed in JWT_PRIVATE_KEYS and the public keys of retired ones in
+// JWT_PUBLIC_KEYS. The first key of the chosen algorithm signs new tokens,
+// all of them are accepted when verifying, so a key can be rotated by putting
+// the new one first and removing the old one once its tokens have expired.
+func keyRingFromEnv() (*KeyRing, error) {
+	ring := &KeyRing{keys: map[string]*SigningKey{}}
+
+	secrets := os.Getenv("JWT_KEYS")
+	if secrets == "" && os.Getenv("JWT_KEY") != "" {
+		secrets = "default:" + os.Getenv("JWT_KEY")
+	}
+
+	entries, err := parseKeyList(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		key := &SigningKey{ID: entry.ID, Method: jwt.SigningMethodHS256, Private: []byte(entry.Value), Public: []byte(entry.Value)}
+		if err = ring.add(key); err != nil {
+			return nil, err
+		}
+
+		if ring.signing == nil {
+			ring.signing = key
+		}
+	}
+
+	var method jwt.SigningMethod
+	switch os.Getenv("JWT_ALGORITHM") {
+	case "", "HS256":
+		if ring.signing == nil {
+			return nil, errors.New("Error JWT_KEY or JWT_KEYS has to be set")
+		}
+
+		return ring, nil
+	case "EdDSA":
+		method = jwt.SigningMethodEdDSA
+	case "RS256":
+		method = jwt.SigningMethodRS256
+	default:
+		return nil, errors.New("Error unsupported JWT_ALGORITHM, use HS256, EdDSA or RS256")
+	}
+
+	ring.signing = nil
+
+	entries, err = parseKeyList(os.Getenv("JWT_PRIVATE_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		private, public, err := readPrivateKey(method, entry.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &SigningKey{ID: entry.ID, Method: method, Private: private, Public: public}
+		if err = ring.add(key); err != nil {
+			return nil, err
+		}
+
+		if ring.signing == nil {
+			ring.signing = key
+		}
+	}
+
+	if ring.signing == nil {
+		return nil, errors.New("Error JWT_PRIVATE_KEYS has to be set when using " + method.Alg())
+	}
+
+	entries, err = parseKeyList(os.Getenv("JWT_PUBLIC_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		public, err := readPublicKey(method, entry.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = ring.add(&SigningKey{ID: entry.ID, Method: method, Public: public}); err != nil {
+			return nil, err
+		}
+	}
+
+	return ring, nil
+}
+
+func LoadKeyRing() error {
+	ring, err := keyRingFromEnv()
+	if err != nil {
+		return err
+	}
+
+	Keys = ring
+	return nil
+}
+
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	if k.signing == nil {
+		return "", errors.New("Error there is no key to sign the token with")
+	}
+
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+	return token.SignedString(k.signing.Private)
+}
+
+// VerificationKey is used as the jwt.Keyfunc when parsing tokens.
+func (k *KeyRing) VerificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, errors.New("Error the token was signed with an unknown key")
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.ErrUnsupported
+	}
+
+	return key.Public, nil
+}
+
+// GetJWKS publishes the public keys, so other services can verify the tokens
+// themselves. HMAC secrets are never part of it.
+func GetJWKS(c *gin.Context) {
+	keys := []gin.H{}
+	for _, key := range Keys.list {
+		switch public := key.Public.(type) {
+		case ed25519.PublicKey:
+			keys = append(keys, gin.H{"kty": "OKP", "crv": "Ed25519", "use": "sig", "alg": key.Method.Alg(), "kid": key.ID,
+				"x": base64.RawURLEncoding.EncodeToString(public)})
+		case *rsa.PublicKey:
+			keys = append(keys, gin.H{"kty": "RSA", "use": "sig", "alg": key.Method.Alg(), "kid": key.ID,
+				"n": base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
@@ -0,0 +1,91 @@
+package authentication
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestParseKeyList(t *testing.T) {
+	tests := []struct {
+		list    string
+		entries []keyEntry
+		err     bool
+	}{
+		{"", []keyEntry{}, false},
+		{"a:secret", []keyEntry{{ID: "a", Value: "secret"}}, false},
+		{"a:one, b:two", []keyEntry{{ID: "a", Value: "one"}, {ID: "b", Value: "two"}}, false},
+		{"a:with:colon", []keyEntry{{ID: "a", Value: "with:colon"}}, false},
+		{"secret", nil, true},
+		{":secret", nil, true},
+		{"a:", nil, true},
+		{"a:one,", nil, true},
+	}
+
+	for _, test := range tests {
+		entries, err := parseKeyList(test.list)
+		if (err != nil) != test.err {
+			t.Errorf("parseKeyList(%q) error = %v, want error %v", test.list, err, test.err)
+			continue
+		}
+
+		if !reflect.DeepEqual(entries, test.entries) {
+			t.Errorf("parseKeyList(%q) = %v, want %v", test.list, entries, test.entries)
+		}
+	}
+}
+
+func TestVerificationKey(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("secret")
+	ring := &KeyRing{keys: map[string]*SigningKey{}}
+	for _, key := range []*SigningKey{
+		{ID: "hmac", Method: jwt.SigningMethodHS256, Private: secret, Public: secret},
+		{ID: "ed", Method: jwt.SigningMethodEdDSA, Private: private, Public: public},
+	} {
+		if err = ring.add(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		kid    interface{}
+		method jwt.SigningMethod
+		key    interface{}
+	}{
+		{"hmac", "hmac", jwt.SigningMethodHS256, secret},
+		{"eddsa", "ed", jwt.SigningMethodEdDSA, public},
+		// A public key must never be accepted as an HMAC secret.
+		{"hmac with the id of an eddsa key", "ed", jwt.SigningMethodHS256, nil},
+		{"eddsa with the id of an hmac key", "hmac", jwt.SigningMethodEdDSA, nil},
+		{"other hmac", "hmac", jwt.SigningMethodHS512, nil},
+		{"unknown key", "other", jwt.SigningMethodHS256, nil},
+		{"no key id", nil, jwt.SigningMethodHS256, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := &jwt.Token{Header: map[string]interface{}{"kid": test.kid}, Method: test.method}
+			key, err := ring.VerificationKey(token)
+			if test.key == nil {
+				if err == nil {
+					t.Errorf("VerificationKey() = %v, want an error", key)
+				}
+
+				return
+			}
+
+			if err != nil || !reflect.DeepEqual(key, test.key) {
+				t.Errorf("VerificationKey() = %v, %v, want %v", key, err, test.key)
+			}
+		})
+	}
+}
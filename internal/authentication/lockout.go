@@ -0,0 +1,155 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxFailedLogins      = 5
+	maxLockDuration      = time.Hour
+	maxFailedLoginsPerIP = 30
+	ipAttemptsWindow     = time.Minute * 15
+)
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// TrustProxies makes c.ClientIP(), which the lockout, the sessions and the
+// audit log go by, only read X-Forwarded-For from the proxies listed in
+// TRUSTED_PROXIES, addresses or CIDR ranges separated by commas. No proxy is
+// trusted by default, so a client can't pick its own IP with the header.
+func TrustProxies(r *gin.Engine) error {
+	proxies := []string{}
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return r.SetTrustedProxies(proxies)
+}
+
+// lockDuration doubles with every failed attempt after the allowed ones,
+// starting from one minute.
+func lockDuration(failures int) time.Duration {
+	if failures < maxFailedLogins {
+		return 0
+	}
+
+	duration := time.Minute << (failures - maxFailedLogins)
+	if duration > maxLockDuration || duration <= 0 {
+		return maxLockDuration
+	}
+
+	return duration
+}
+
+// emailPseudonym is what the login attempts and the audit log keep instead of
+// an email, so they don't hold on to the address of someone who deleted their
+// account. The same email, in any case, always gives the same pseudonym, so
+// the attempts can still be counted per email.
+func emailPseudonym(email string) string {
+	return hashToken(strings.ToLower(email))
+}
+
+// LoginBlocked works the same way for emails that aren't registered, so it
+// doesn't reveal which ones are.
+func (r *AuthRepository) LoginBlocked(ctx context.Context, email, ip string) (bool, error) {
+	ipFailures := 0
+	err := r.db.QueryRow(ctx, "select count(*) from e_commerce.login_attempts where ip = $1 and not success and attempted_at > $2",
+		ip, time.Now().Add(-ipAttemptsWindow)).Scan(&ipFailures)
+	if err != nil {
+		return false, err
+	}
+
+	if ipFailures >= maxFailedLoginsPerIP {
+		return true, nil
+	}
+
+	failures := 0
+	var lastFailure *time.Time
+	err = r.db.QueryRow(ctx, "select count(*), max(attempted_at) from e_commerce.login_attempts where email = $1 and not success and not cleared "+
+		"and attempted_at > now() - interval '1 day'", emailPseudonym(email)).Scan(&failures, &lastFailure)
+	if err != nil {
+		return false, err
+	}
+
+	if lastFailure == nil {
+		return false, nil
+	}
+
+	return time.Since(*lastFailure) < lockDuration(failures), nil
+}
+
+func (r *AuthRepository) RecordLoginAttempt(ctx context.Context, email, ip string, success bool) error {
+	_, err := r.db.Exec(ctx, "insert into e_commerce.login_attempts (email, ip, success) values ($1, $2, $3)", emailPseudonym(email), ip, success)
+	if err != nil {
+		return err
+	}
+
+	if !success {
+		return nil
+	}
+
+	return r.clearFailedLogins(ctx, email)
+}
+
+func (r *AuthRepository) clearFailedLogins(ctx context.Context, email string) error {
+	_, err := r.db.Exec(ctx, "update e_commerce.login_attempts set cleared = true where email = $1 and not success and not cleared", emailPseudonym(email))
+	return err
+}
+
+// checkDummyPassword takes as long as checking a real password, so that the
+// response time doesn't show whether an email is registered.
+func checkDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		hash, err := HashPassword("dummy password")
+		if err != nil {
+			log.Println(err)
+		}
+
+		dummyHash = hash
+	})
+
+	VerifyPassword(password, dummyHash)
+}
+
+func (h *AuthHandler) UnlockAccount(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // email
+
+	email, ok := information["email"]
+	if !ok {
+		log.Println("Incorrectly provided email")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided email"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.clearFailedLogins(ctx, email); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "admin.unlock", "user", emailPseudonym(email), nil, nil)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to unlock the account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
@@ -0,0 +1,85 @@
+package authentication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLockDuration(t *testing.T) {
+	tests := []struct {
+		failures int
+		duration time.Duration
+	}{
+		{0, 0},
+		{maxFailedLogins - 1, 0},
+		{maxFailedLogins, time.Minute},
+		{maxFailedLogins + 1, time.Minute * 2},
+		{maxFailedLogins + 5, time.Minute * 32},
+		{maxFailedLogins + 6, maxLockDuration},
+		{maxFailedLogins + 100, maxLockDuration},
+	}
+
+	for _, test := range tests {
+		if duration := lockDuration(test.failures); duration != test.duration {
+			t.Errorf("lockDuration(%d) = %v, want %v", test.failures, duration, test.duration)
+		}
+	}
+}
+
+func TestTrustProxies(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxies   string
+		forwarded []string
+		ip        string
+	}{
+		// A client forging a new address on every attempt is still counted
+		// under the address it connects from.
+		{"no trusted proxies", "", []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"}, "203.0.113.7"},
+		{"untrusted proxy", "10.0.0.0/8", []string{"198.51.100.1", "198.51.100.2"}, "203.0.113.7"},
+		{"trusted proxy", "203.0.113.0/24", []string{"198.51.100.1"}, "198.51.100.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", test.proxies)
+
+			r := gin.New()
+			if err := TrustProxies(r); err != nil {
+				t.Fatal(err)
+			}
+			r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+			for _, forwarded := range test.forwarded {
+				request := httptest.NewRequest(http.MethodGet, "/", nil)
+				request.RemoteAddr = "203.0.113.7:4000"
+				request.Header.Set("X-Forwarded-For", forwarded)
+
+				recorder := httptest.NewRecorder()
+				r.ServeHTTP(recorder, request)
+				if ip := recorder.Body.String(); ip != test.ip {
+					t.Errorf("ClientIP() with X-Forwarded-For %s = %s, want %s", forwarded, ip, test.ip)
+				}
+			}
+		})
+	}
+}
+
+func TestEmailPseudonym(t *testing.T) {
+	// The sha256 of "user@example.com", which migration 0014 computes in SQL.
+	const pseudonym = "b4c9a289323b21a01c3e940f150eb9b8c542587f1abfd8f0e1cc1ffc5e475514"
+
+	for _, email := range []string{"user@example.com", "User@Example.com", "USER@EXAMPLE.COM"} {
+		if got := emailPseudonym(email); got != pseudonym {
+			t.Errorf("emailPseudonym(%q) = %s, want %s", email, got, pseudonym)
+		}
+	}
+
+	if emailPseudonym("other@example.com") == pseudonym {
+		t.Error("different emails have the same pseudonym")
+	}
+}
@@ -0,0 +1,156 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/emails"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	LoginLinkDuration = time.Minute * 15
+	loginLinkDelay    = time.Minute
+)
+
+// verifyUser marks the email of the account as verified and returns it
+// together with the type of the account.
+func (r *AuthRepository) verifyUser(ctx context.Context, userID int) (string, byte, error) {
+	var email string
+	var accountType byte
+	err := r.db.QueryRow(ctx, "update e_commerce.authentication set verified = true where id = $1 and deleted_at is null returning email, type", userID).
+		Scan(&email, &accountType)
+	return email, accountType, err
+}
+
+// SendLoginLink emails a link which logs the user in without a password.
+func (h *AuthHandler) SendLoginLink(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // email
+
+	email, ok := information["email"]
+	if !ok {
+		log.Println("Incorrectly provided email")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided email"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	// Just like with the password resets, the response doesn't show whether
+	// the email is registered.
+	id, err := h.users.userIDByEmail(ctx, email)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusOK, nil)
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	recent, err := h.users.recentLink(ctx, "e_commerce.login_links", id, loginLinkDelay)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if recent {
+		c.JSON(http.StatusOK, nil)
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to create a login token"})
+		return
+	}
+
+	if err = h.users.createLink(ctx, "e_commerce.login_links", id, hashToken(token), LoginLinkDuration); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information in the database"})
+		return
+	}
+
+	link := loginLinkURL(token)
+	err = emails.Send(ctx, h.db, email, "Your login link", "Open the following link to log in:\n\n"+link+
+		"\n\nThe link expires in 15 minutes and can be used only once. If you didn't request this, you can ignore this email.")
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to send the email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+// loginLinkURL is the link sent in the email. It opens LOGIN_LINK_URL, a page
+// of the frontend which posts the token to /login/link/verify, or without one
+// the /login/link/verify route of APP_URL directly.
+func loginLinkURL(token string) string {
+	base := os.Getenv("LOGIN_LINK_URL")
+	if base == "" {
+		base = strings.TrimSuffix(os.Getenv("APP_URL"), "/") + "/login/link/verify"
+	}
+
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+// LogInWithLink exchanges the token from a login link for the same tokens
+// LogIn gives out. Two-factor authentication still applies. The token is
+// posted or, when the link is opened directly, in the query.
+func (h *AuthHandler) LogInWithLink(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // token
+
+	token, ok := information["token"]
+	if !ok {
+		token = c.Query("token")
+		ok = token != ""
+	}
+
+	if !ok {
+		log.Println("Incorrectly provided login token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided login token"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID, err := h.users.useLink(ctx, "e_commerce.login_links", hashToken(token))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Error the login link is invalid or has expired"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	// Opening the link proves that the user owns the email, so it counts as
+	// verifying it.
+	email, accountType, err := h.users.verifyUser(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Error the login link is invalid or has expired"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	h.completeLogIn(c, userID, accountType, email, "link")
+}
@@ -0,0 +1,27 @@
+package authentication
+
+import "testing"
+
+func TestLoginLinkURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		appURL    string
+		loginLink string
+		link      string
+	}{
+		{"server route", "https://shop.example.com", "", "https://shop.example.com/login/link/verify?token=a%2Bb"},
+		{"trailing slash", "https://shop.example.com/", "", "https://shop.example.com/login/link/verify?token=a%2Bb"},
+		{"frontend page", "https://api.example.com", "https://example.com/login", "https://example.com/login?token=a%2Bb"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("APP_URL", test.appURL)
+			t.Setenv("LOGIN_LINK_URL", test.loginLink)
+
+			if link := loginLinkURL("a+b"); link != test.link {
+				t.Errorf("loginLinkURL() = %s, want %s", link, test.link)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package authentication
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Authenticate validates the bearer token from the Authorization header and
+// stores the user it belongs to in the context for the following handlers.
+// The token can also be an API key, which only carries its permissions.
+func (h *AuthHandler) Authenticate(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Error missing authorization token"})
+		return
+	}
+
+	if isAPIKey(token) {
+		id, permissions, err := h.users.ValidateAPIKey(c.Request.Context(), token)
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Error invalid API key"})
+			return
+		}
+
+		c.Set("apiKeyID", id)
+		c.Set("permissions", permissions)
+		c.Next()
+		return
+	}
+
+	claims, err := h.ValidateJWT(c.Request.Context(), token, c.ClientIP())
+	if err != nil {
+		log.Println(err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
+		return
+	}
+
+	if claims.Impersonator != 0 {
+		if c.Request.Method != http.MethodGet {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Error impersonation is read-only"})
+			return
+		}
+
+		log.Println("User", claims.Impersonator, "impersonating user", claims.UserID(), "requested", c.Request.URL.Path)
+		c.Set("impersonator", claims.Impersonator)
+	}
+
+	c.Set("id", claims.UserID())
+	c.Set("type", claims.Type)
+	c.Set("email", claims.Email)
+	c.Set("permissions", claims.Permissions)
+	c.Set("sid", claims.SessionID)
+	c.Set("jti", claims.ID)
+	c.Set("expiration", claims.ExpiresAt.Unix())
+	c.Next()
+}
+
+func CurrentUserID(c *gin.Context) int {
+	return c.GetInt("id")
+}
+
+func CurrentAccountType(c *gin.Context) byte {
+	accountType, _ := c.Get("type")
+	result, _ := accountType.(byte)
+	return result
+}
+
+func CurrentEmail(c *gin.Context) string {
+	return c.GetString("email")
+}
+
+func CurrentPermissions(c *gin.Context) []string {
+	return c.GetStringSlice("permissions")
+}
+
+func CurrentSessionID(c *gin.Context) int {
+	return c.GetInt("sid")
+}
+
+func CurrentImpersonatorID(c *gin.Context) int {
+	return c.GetInt("impersonator")
+}
+
+func CurrentAPIKeyID(c *gin.Context) int {
+	return c.GetInt("apiKeyID")
+}
+
+func CurrentTokenID(c *gin.Context) (string, int64) {
+	return c.GetString("jti"), c.GetInt64("expiration")
+}
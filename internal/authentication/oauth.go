@@ -0,0 +1,509 @@
+package authentication
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const OAuthStateDuration = time.Minute * 10
+
+var ErrOAuthEmailNotVerified = errors.New("Error the login provider hasn't verified your email")
+
+var oauthClient = &http.Client{Timeout: time.Second * 10}
+
+// OAuthProvider is an OpenID Connect provider users can log in with. The
+// endpoints and keys of the provider are discovered from its issuer.
+type OAuthProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	mutex    sync.Mutex
+	metadata *oidcMetadata
+	keys     map[string]interface{}
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type idTokenClaims struct {
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	Name          string      `json:"name"`
+	Nonce         string      `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// OAuthProviders are read from the environment. OAUTH_PROVIDERS lists their
+// names and every one of them is configured with OAUTH_<NAME>_ISSUER,
+// OAUTH_<NAME>_CLIENT_ID, OAUTH_<NAME>_CLIENT_SECRET and optionally
+// OAUTH_<NAME>_REDIRECT_URL and OAUTH_<NAME>_SCOPES.
+var OAuthProviders = oauthProvidersFromEnv()
+
+func oauthProvidersFromEnv() map[string]*OAuthProvider {
+	providers := map[string]*OAuthProvider{}
+	for _, name := range strings.Split(os.Getenv("OAUTH_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OAUTH_" + strings.ToUpper(name) + "_"
+		provider := &OAuthProvider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+
+		if provider.Issuer == "" || provider.ClientID == "" {
+			log.Println("Ignoring the OAuth provider", name, "because its issuer or client id is missing")
+			continue
+		}
+
+		if provider.RedirectURL == "" {
+			provider.RedirectURL = os.Getenv("APP_URL") + "/oauth/" + name + "/callback"
+		}
+
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+
+		providers[name] = provider
+	}
+
+	return providers
+}
+
+func getJSON(address string, value interface{}) error {
+	response, err := oauthClient.Get(address)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error %s responded with status %d", address, response.StatusCode)
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+func (p *OAuthProvider) discover() (*oidcMetadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	metadata := &oidcMetadata{}
+	if err := getJSON(p.Issuer+"/.well-known/openid-configuration", metadata); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.Issuer {
+		return nil, errors.New("Error the issuer of the provider doesn't match its configuration")
+	}
+
+	p.metadata = metadata
+	return metadata, nil
+}
+
+func (k *jsonWebKey) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.New("Error unsupported curve " + k.Crv)
+		}
+
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errors.New("Error unsupported curve " + k.Crv)
+		}
+
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.New("Error unsupported key type " + k.Kty)
+	}
+}
+
+// verificationKey finds the key an id token was signed with. The keys of the
+// provider are fetched again when the kid isn't known, since providers rotate
+// their keys.
+func (p *OAuthProvider) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(p.metadata.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	p.keys = map[string]interface{}{}
+	for _, webKey := range set.Keys {
+		key, err := webKey.publicKey()
+		if err != nil {
+			continue
+		}
+
+		p.keys[webKey.Kid] = key
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, errors.New("Error the id token was signed with an unknown key")
+	}
+
+	return key, nil
+}
+
+// exchangeCode trades the authorization code for the tokens of the user and
+// returns the verified claims of the id token.
+func (p *OAuthProvider) exchangeCode(metadata *oidcMetadata, code, verifier, nonce string) (*idTokenClaims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", verifier)
+
+	request, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	response, err := oauthClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error the token endpoint responded with status %d", response.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+
+	claims := &idTokenClaims{}
+	_, err = jwt.ParseWithClaims(tokens.IDToken, claims, p.verificationKey, jwt.WithIssuer(metadata.Issuer), jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(), jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}))
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Nonce != nonce {
+		return nil, errors.New("Error the nonce of the id token doesn't match")
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("Error the id token has no subject")
+	}
+
+	return claims, nil
+}
+
+// emailVerified handles providers that send email_verified as a string.
+func (c *idTokenClaims) emailVerified() bool {
+	switch verified := c.EmailVerified.(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	default:
+		return false
+	}
+}
+
+// saveOAuthState keeps what's needed to finish the login once the user comes
+// back from the provider and clears the states nobody came back for.
+func (r *AuthRepository) saveOAuthState(ctx context.Context, stateHash, provider, verifier, nonce string) error {
+	_, err := r.db.Exec(ctx, "delete from e_commerce.oauth_states where expires_at < now()")
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, "insert into e_commerce.oauth_states (state_hash, provider, code_verifier, nonce, expires_at) values ($1, $2, $3, $4, $5)",
+		stateHash, provider, verifier, nonce, time.Now().Add(OAuthStateDuration))
+	return err
+}
+
+// takeOAuthState returns the code verifier and the nonce of a state, which can
+// only be used once.
+func (r *AuthRepository) takeOAuthState(ctx context.Context, stateHash, provider string) (string, string, error) {
+	var verifier, nonce string
+	err := r.db.QueryRow(ctx, "delete from e_commerce.oauth_states where state_hash = $1 and provider = $2 and expires_at > now() returning code_verifier, nonce",
+		stateHash, provider).Scan(&verifier, &nonce)
+	return verifier, nonce, err
+}
+
+// StartOAuthLogIn redirects the user to the provider, using PKCE so the code
+// that comes back is useless to anyone who intercepts it.
+func (h *AuthHandler) StartOAuthLogIn(c *gin.Context) {
+	provider, ok := OAuthProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error unknown login provider"})
+		return
+	}
+
+	metadata, err := provider.discover()
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Error unable to reach the login provider"})
+		return
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	nonce, err := randomToken(16)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	verifier, err := randomToken(32)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err = h.users.saveOAuthState(ctx, hashToken(state), provider.Name, verifier, nonce); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information in the database"})
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", provider.ClientID)
+	values.Set("redirect_uri", provider.RedirectURL)
+	values.Set("scope", strings.Join(provider.Scopes, " "))
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	values.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	values.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	c.Redirect(http.StatusFound, metadata.AuthorizationEndpoint+separator+values.Encode())
+}
+
+// oauthUser finds the account a social login belongs to. Accounts are linked
+// by email only when the provider has verified it, otherwise anybody could
+// take over an account by signing up at the provider with its email.
+func (r *AuthRepository) oauthUser(ctx context.Context, provider string, claims *idTokenClaims) (int, error) {
+	userID := 0
+	err := r.db.QueryRow(ctx, "select i.user_id from e_commerce.oauth_identities i join e_commerce.authentication a on a.id = i.user_id "+
+		"where i.provider = $1 and i.subject = $2 and a.deleted_at is null", provider, claims.Subject).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+
+	if err != pgx.ErrNoRows {
+		return 0, err
+	}
+
+	if claims.Email == "" || !claims.emailVerified() {
+		return 0, ErrOAuthEmailNotVerified
+	}
+
+	err = r.inTx(ctx, func(tx *AuthRepository) error {
+		err := tx.db.QueryRow(ctx, "select id from e_commerce.authentication where email = $1 and deleted_at is null", claims.Email).Scan(&userID)
+		if err == pgx.ErrNoRows {
+			name := claims.Name
+			if name == "" {
+				name = claims.Email
+			}
+
+			// Accounts created this way have no password until the user sets one
+			// through the password reset.
+			err = tx.db.QueryRow(ctx, "insert into e_commerce.authentication (name, email, password, type, points, verified) values ($1, $2, '', $3, 0, true) returning id",
+				name, claims.Email, User).Scan(&userID)
+		} else if err == nil {
+			_, err = tx.db.Exec(ctx, "update e_commerce.authentication set verified = true where id = $1", userID)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.db.Exec(ctx, "insert into e_commerce.oauth_identities (user_id, provider, subject, email) values ($1, $2, $3, $4)",
+			userID, provider, claims.Subject, claims.Email)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (h *AuthHandler) OAuthCallback(c *gin.Context) {
+	provider, ok := OAuthProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error unknown login provider"})
+		return
+	}
+
+	if reason := c.Query("error"); reason != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error the login provider refused the login: " + reason})
+		return
+	}
+
+	state := c.Query("state")
+	code := c.Query("code")
+	if state == "" || code == "" {
+		log.Println("Incorrectly provided state or code")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided state or code"})
+		return
+	}
+
+	metadata, err := provider.discover()
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Error unable to reach the login provider"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	verifier, nonce, err := h.users.takeOAuthState(ctx, hashToken(state), provider.Name)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Error the login has expired, try again"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	claims, err := provider.exchangeCode(metadata, code, verifier, nonce)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error unable to log in with the provider"})
+		return
+	}
+
+	userID, err := h.users.oauthUser(ctx, provider.Name, claims)
+	if err != nil {
+		if err == ErrOAuthEmailNotVerified {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to link the login to an account"})
+		return
+	}
+
+	account, err := h.users.activeAccount(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	h.completeLogIn(c, userID, account.Type, account.Email, "oauth:"+c.Param("provider"))
+}
@@ -0,0 +1,258 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes passwords into a self-describing string, so the
+// scheme and cost used for a stored password can be read back from it.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	NeedsRehash(encoded string) bool
+}
+
+// Passwords is the hasher used for new passwords. LoadPasswordHasher picks it
+// from the PASSWORD_HASHER environment variable ("argon2id" by default or
+// "bcrypt").
+var Passwords PasswordHasher = NewArgon2idHasher()
+
+var legacySHA512 = regexp.MustCompile("^[0-9a-f]{128}$")
+
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	check := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(check, key) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != h.Memory || params.Iterations != h.Iterations || params.Parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength || uint32(len(key)) != h.KeyLength
+}
+
+func decodeArgon2id(encoded string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, errors.New("Error the password isn't an argon2id hash")
+	}
+
+	version := 0
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if version != argon2.Version {
+		return nil, nil, nil, errors.New("Error unsupported version of argon2")
+	}
+
+	params := &Argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return nil, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return params, salt, key, nil
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher() *BcryptHasher {
+	return &BcryptHasher{Cost: 12}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err != nil {
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+
+	return cost != h.Cost
+}
+
+func passwordHasherFromEnv() (PasswordHasher, error) {
+	switch os.Getenv("PASSWORD_HASHER") {
+	case "bcrypt":
+		hasher := NewBcryptHasher()
+		cost, ok, err := intFromEnv("BCRYPT_COST", bcrypt.MinCost, bcrypt.MaxCost)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			hasher.Cost = cost
+		}
+
+		return hasher, nil
+	default:
+		hasher := NewArgon2idHasher()
+		memory, ok, err := intFromEnv("ARGON2_MEMORY", 1, math.MaxUint32)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			hasher.Memory = uint32(memory)
+		}
+
+		iterations, ok, err := intFromEnv("ARGON2_ITERATIONS", 1, math.MaxUint32)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			hasher.Iterations = uint32(iterations)
+		}
+
+		parallelism, ok, err := intFromEnv("ARGON2_PARALLELISM", 1, math.MaxUint8)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			hasher.Parallelism = uint8(parallelism)
+		}
+
+		return hasher, nil
+	}
+}
+
+// LoadPasswordHasher sets Passwords from the environment. A setting which is
+// out of range is an error, so it stops the server when it starts instead of
+// breaking every login.
+func LoadPasswordHasher() error {
+	hasher, err := passwordHasherFromEnv()
+	if err != nil {
+		return err
+	}
+
+	Passwords = hasher
+	return nil
+}
+
+// intFromEnv reports whether the variable is set, and returns an error when
+// it isn't a number from min to max.
+func intFromEnv(name string, min, max int) (int, bool, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return 0, false, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, false, fmt.Errorf("Error %s has to be a number from %d to %d", name, min, max)
+	}
+
+	return number, true, nil
+}
+
+func HashPassword(password string) (string, error) {
+	return Passwords.Hash(password)
+}
+
+// VerifyPassword checks a password against a stored hash of any scheme the
+// shop has used. The second result reports whether the stored hash should be
+// replaced with one made by Passwords.
+func VerifyPassword(password, encoded string) (bool, bool, error) {
+	var scheme PasswordHasher
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		scheme = NewArgon2idHasher()
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		scheme = NewBcryptHasher()
+	case encoded == "":
+		// Accounts created through a social login have no password.
+		return false, false, nil
+	case legacySHA512.MatchString(encoded):
+		match := subtle.ConstantTimeCompare([]byte(SHA512(password)), []byte(encoded)) == 1
+		return match, match, nil
+	default:
+		return false, false, errors.New("Error unknown password hash format")
+	}
+
+	match, err := scheme.Verify(password, encoded)
+	if err != nil || !match {
+		return false, false, err
+	}
+
+	return true, Passwords.NeedsRehash(encoded), nil
+}
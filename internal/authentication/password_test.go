@@ -0,0 +1,99 @@
+package authentication
+
+import (
+	"os"
+	"testing"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	// Cheap parameters keep the test fast, the hashes are checked the same way.
+	current := &Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	older := &Argon2idHasher{Memory: 512, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	previous := Passwords
+	Passwords = current
+	defer func() { Passwords = previous }()
+
+	hash := func(hasher PasswordHasher, password string) string {
+		encoded, err := hasher.Hash(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return encoded
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		encoded  string
+		match    bool
+		rehash   bool
+		err      bool
+	}{
+		{"argon2id", "secret", hash(current, "secret"), true, false, false},
+		{"argon2id wrong password", "wrong", hash(current, "secret"), false, false, false},
+		{"argon2id old parameters", "secret", hash(older, "secret"), true, true, false},
+		{"bcrypt", "secret", hash(&BcryptHasher{Cost: 4}, "secret"), true, true, false},
+		{"bcrypt wrong password", "wrong", hash(&BcryptHasher{Cost: 4}, "secret"), false, false, false},
+		{"legacy sha512", "secret", SHA512("secret"), true, true, false},
+		{"legacy sha512 wrong password", "wrong", SHA512("secret"), false, false, false},
+		{"no password", "secret", "", false, false, false},
+		{"unknown format", "secret", "plaintext", false, false, true},
+		{"broken argon2id", "secret", "$argon2id$v=19$m=1024", false, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match, rehash, err := VerifyPassword(test.password, test.encoded)
+			if (err != nil) != test.err {
+				t.Fatalf("error = %v, want error %v", err, test.err)
+			}
+
+			if match != test.match || rehash != test.rehash {
+				t.Errorf("VerifyPassword() = %v, %v, want %v, %v", match, rehash, test.match, test.rehash)
+			}
+		})
+	}
+}
+
+func TestPasswordHasherFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		valid bool
+	}{
+		{"defaults", map[string]string{}, true},
+		{"argon2id", map[string]string{"ARGON2_MEMORY": "1024", "ARGON2_ITERATIONS": "2", "ARGON2_PARALLELISM": "255"}, true},
+		{"too much parallelism", map[string]string{"ARGON2_PARALLELISM": "256"}, false},
+		{"no parallelism", map[string]string{"ARGON2_PARALLELISM": "0"}, false},
+		{"too many iterations", map[string]string{"ARGON2_ITERATIONS": "4294967296"}, false},
+		{"not a number", map[string]string{"ARGON2_MEMORY": "64MB"}, false},
+		{"bcrypt", map[string]string{"PASSWORD_HASHER": "bcrypt", "BCRYPT_COST": "10"}, true},
+		{"bcrypt cost too high", map[string]string{"PASSWORD_HASHER": "bcrypt", "BCRYPT_COST": "32"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"PASSWORD_HASHER", "BCRYPT_COST", "ARGON2_MEMORY", "ARGON2_ITERATIONS", "ARGON2_PARALLELISM"} {
+				t.Setenv(name, test.env[name])
+				if _, ok := test.env[name]; !ok {
+					os.Unsetenv(name)
+				}
+			}
+
+			hasher, err := passwordHasherFromEnv()
+			if (err == nil) != test.valid {
+				t.Fatalf("passwordHasherFromEnv() error = %v, want valid %v", err, test.valid)
+			}
+
+			if hasher == nil {
+				return
+			}
+
+			if _, err = hasher.Hash("secret"); err != nil {
+				t.Errorf("Hash() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package authentication
+
+import (
+	"context"
+)
+
+// AddPoints changes the points of a user and keeps a record of why it happened.
+func (r *AuthRepository) AddPoints(ctx context.Context, userID, points int, reason string) error {
+	return r.inTx(ctx, func(tx *AuthRepository) error {
+		_, err := tx.db.Exec(ctx, "update e_commerce.authentication set points = points + $1 where id = $2", points, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.db.Exec(ctx, "insert into e_commerce.points_history (user_id, points, reason) values ($1, $2, $3)", userID, points, reason)
+		return err
+	})
+}
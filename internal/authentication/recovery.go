@@ -0,0 +1,226 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/Phantomvv1/E-commerce/internal/emails"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	PasswordResetDuration = time.Hour
+	passwordResetDelay    = time.Minute
+	minPasswordLength     = 8
+)
+
+// expirePasswordResets uses up every reset link of the user, so the ones sent
+// before the link which was opened can't be used anymore.
+func (r *AuthRepository) expirePasswordResets(ctx context.Context, userID int) error {
+	_, err := r.db.Exec(ctx, "update e_commerce.password_resets set used = true where user_id = $1", userID)
+	return err
+}
+
+func (r *AuthRepository) setPassword(ctx context.Context, userID int, password string) error {
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err = r.rehashPassword(ctx, userID, hashedPassword); err != nil {
+		return err
+	}
+
+	return r.RevokeAllTokens(ctx, userID)
+}
+
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // email
+
+	email, ok := information["email"]
+	if !ok {
+		log.Println("Incorrectly provided email")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided email"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	// The response is the same whether the email is registered or not, so
+	// this endpoint can't be used to find out who has an account.
+	id, err := h.users.userIDByEmail(ctx, email)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusOK, nil)
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	recent, err := h.users.recentLink(ctx, "e_commerce.password_resets", id, passwordResetDelay)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if recent {
+		c.JSON(http.StatusOK, nil)
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to create a reset token"})
+		return
+	}
+
+	if err = h.users.createLink(ctx, "e_commerce.password_resets", id, hashToken(token), PasswordResetDuration); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information in the database"})
+		return
+	}
+
+	link := os.Getenv("APP_URL") + "/password/reset?token=" + url.QueryEscape(token)
+	err = emails.Send(ctx, h.db, email, "Reset your password", "Open the following link to choose a new password:\n\n"+link+
+		"\n\nThe link expires in 1 hour and can be used only once. If you didn't request this, you can ignore this email.")
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to send the email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // token && password
+
+	token, ok := information["token"]
+	if !ok {
+		log.Println("Incorrectly provided reset token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided reset token"})
+		return
+	}
+
+	password, ok := information["password"]
+	if !ok || len(password) < minPasswordLength {
+		log.Println("Incorrectly provided password")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error the password has to be at least 8 characters long"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := h.users.inTx(ctx, func(tx *AuthRepository) error {
+		userID, err := tx.useLink(ctx, "e_commerce.password_resets", hashToken(token))
+		if err != nil {
+			return err
+		}
+
+		if err = tx.expirePasswordResets(ctx, userID); err != nil {
+			return err
+		}
+
+		if err = tx.setPassword(ctx, userID, password); err != nil {
+			return err
+		}
+
+		return audit.RecordAs(c, tx.db, userID, "auth.password_reset", "user", userID, nil, nil)
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error the reset link is invalid or has expired"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to reset your password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // oldPassword && newPassword
+
+	id := CurrentUserID(c)
+
+	oldPassword, ok := information["oldPassword"]
+	if !ok {
+		log.Println("Incorrectly provided old password")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided old password"})
+		return
+	}
+
+	newPassword, ok := information["newPassword"]
+	if !ok || len(newPassword) < minPasswordLength {
+		log.Println("Incorrectly provided new password")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error the new password has to be at least 8 characters long"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	profile, err := h.users.Profile(ctx, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	passwordCheck, err := h.users.passwordHash(ctx, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	match, _, err := VerifyPassword(oldPassword, passwordCheck)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to check your password"})
+		return
+	}
+
+	if !match {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error wrong password"})
+		return
+	}
+
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.setPassword(ctx, id, newPassword); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "auth.password_change", "user", id, nil, nil)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to change your password"})
+		return
+	}
+
+	// Every session, including this one, was just revoked, so the user gets a
+	// fresh pair of tokens to stay logged in here.
+	jwtToken, refreshToken, err := h.IssueTokens(c, id, profile.Type, profile.Email)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while generating your token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": jwtToken, "refreshToken": refreshToken})
+}
@@ -0,0 +1,67 @@
+package authentication
+
+import (
+	"context"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	"github.com/jackc/pgx/v5"
+)
+
+// AuthRepository runs the queries for the accounts and everything that
+// belongs to them, like tokens, sessions, roles and second factors.
+type AuthRepository struct {
+	db database.DB
+}
+
+func NewAuthRepository(db database.DB) *AuthRepository {
+	return &AuthRepository{db: db}
+}
+
+// inTx runs fn with a repository bound to a transaction.
+func (r *AuthRepository) inTx(ctx context.Context, fn func(tx *AuthRepository) error) error {
+	return database.InTx(ctx, r.db, func(tx pgx.Tx) error {
+		return fn(&AuthRepository{db: tx})
+	})
+}
+
+// userIDByEmail returns the id of the account with this email which isn't
+// deleted.
+func (r *AuthRepository) userIDByEmail(ctx context.Context, email string) (int, error) {
+	id := 0
+	err := r.db.QueryRow(ctx, "select id from e_commerce.authentication where email = $1 and deleted_at is null", email).Scan(&id)
+	return id, err
+}
+
+// The login links and the password resets are kept in tables with the same
+// columns, so the following work on either of them.
+
+// recentLink reports whether a link was sent to the user in the last delay.
+func (r *AuthRepository) recentLink(ctx context.Context, table string, userID int, delay time.Duration) (bool, error) {
+	recent := false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from "+table+" where user_id = $1 and created_at > $2)", userID, time.Now().Add(-delay)).Scan(&recent)
+	return recent, err
+}
+
+func (r *AuthRepository) createLink(ctx context.Context, table string, userID int, tokenHash string, duration time.Duration) error {
+	_, err := r.db.Exec(ctx, "insert into "+table+" (user_id, token_hash, expires_at) values ($1, $2, $3)", userID, tokenHash, time.Now().Add(duration))
+	return err
+}
+
+// useLink marks the link as used and returns the user it was sent to. It
+// returns pgx.ErrNoRows if the link was already used or has expired.
+func (r *AuthRepository) useLink(ctx context.Context, table, tokenHash string) (int, error) {
+	userID := 0
+	err := r.db.QueryRow(ctx, "update "+table+" set used = true where token_hash = $1 and used = false and expires_at > now() returning user_id", tokenHash).
+		Scan(&userID)
+	return userID, err
+}
+
+type AuthHandler struct {
+	db    database.DB
+	users *AuthRepository
+}
+
+func NewAuthHandler(db database.DB) *AuthHandler {
+	return &AuthHandler{db: db, users: NewAuthRepository(db)}
+}
@@ -0,0 +1,418 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	CatalogEditor = iota + 3
+	Support
+)
+
+const (
+	PermissionItemsWrite       = "items.write"
+	PermissionUsersRead        = "users.read"
+	PermissionUsersManage      = "users.manage"
+	PermissionRolesManage      = "roles.manage"
+	PermissionCartsRead        = "carts.read"
+	PermissionAPIKeysManage    = "apikeys.manage"
+	PermissionUsersImpersonate = "users.impersonate"
+	PermissionAuditRead        = "audit.read"
+	PermissionInventoryWrite   = "inventory.write"
+)
+
+var AllPermissions = []string{
+	PermissionItemsWrite,
+	PermissionUsersRead,
+	PermissionUsersManage,
+	PermissionRolesManage,
+	PermissionCartsRead,
+	PermissionAPIKeysManage,
+	PermissionUsersImpersonate,
+	PermissionAuditRead,
+	PermissionInventoryWrite,
+}
+
+var (
+	errTooManyRoles  = errors.New("Error there are too many roles")
+	errRoleNameTaken = errors.New("Error there is already a role with this name")
+)
+
+type Role struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// GrantAdminPermissions gives the admin role every permission. The role can't
+// be edited, so this runs on startup to add the permissions which are newer
+// than the migration that created it.
+func (r *AuthRepository) GrantAdminPermissions(ctx context.Context) error {
+	_, err := r.db.Exec(ctx, "insert into e_commerce.role_permissions (role_id, permission) select $1, unnest($2::text[]) on conflict do nothing",
+		Admin, AllPermissions)
+	return err
+}
+
+func (r *AuthRepository) RolePermissions(ctx context.Context, roleID byte) ([]string, error) {
+	rows, err := r.db.Query(ctx, "select permission from e_commerce.role_permissions where role_id = $1 order by permission", roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		permission := ""
+		if err = rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, rows.Err()
+}
+
+func (r *AuthRepository) Roles(ctx context.Context) ([]Role, error) {
+	rows, err := r.db.Query(ctx, "select r.id, r.name, coalesce(array_agg(p.permission order by p.permission) filter (where p.permission is not null), '{}') "+
+		"from e_commerce.roles r left join e_commerce.role_permissions p on p.role_id = r.id group by r.id order by r.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		role := Role{}
+		if err = rows.Scan(&role.ID, &role.Name, &role.Permissions); err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (r *AuthRepository) roleExists(ctx context.Context, id int) (bool, error) {
+	exists := false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from e_commerce.roles where id = $1)", id).Scan(&exists)
+	return exists, err
+}
+
+// CreateRole returns errTooManyRoles once the ids of the roles no longer fit
+// in the type of an account, which is a single byte.
+func (r *AuthRepository) CreateRole(ctx context.Context, name string, permissions []string) (int, error) {
+	lastID := 0
+	err := r.db.QueryRow(ctx, "select last_value from e_commerce.roles_id_seq").Scan(&lastID)
+	if err != nil {
+		return 0, err
+	}
+
+	if lastID >= 255 {
+		return 0, errTooManyRoles
+	}
+
+	id := 0
+	err = r.inTx(ctx, func(tx *AuthRepository) error {
+		err := tx.db.QueryRow(ctx, "insert into e_commerce.roles (name) values ($1) on conflict do nothing returning id", name).Scan(&id)
+		if err == pgx.ErrNoRows {
+			return errRoleNameTaken
+		}
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.db.Exec(ctx, "insert into e_commerce.role_permissions (role_id, permission) select $1, unnest($2::text[])", id, permissions)
+		return err
+	})
+
+	return id, err
+}
+
+// replaceRolePermissions returns the permissions the role had before. Since
+// they are part of the access tokens, the tokens of the role are revoked.
+func (r *AuthRepository) replaceRolePermissions(ctx context.Context, id int, permissions []string) ([]string, error) {
+	var before []string
+	err := r.db.QueryRow(ctx, "select coalesce(array_agg(p.permission order by p.permission) filter (where p.permission is not null), '{}') "+
+		"from e_commerce.roles r left join e_commerce.role_permissions p on p.role_id = r.id where r.id = $1 group by r.id", id).Scan(&before)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.db.Exec(ctx, "delete from e_commerce.role_permissions where role_id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.db.Exec(ctx, "insert into e_commerce.role_permissions (role_id, permission) select $1, unnest($2::text[])", id, permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.db.Exec(ctx, "update e_commerce.authentication set tokens_revoked_at = $2 where type = $1", id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return before, nil
+}
+
+// DeleteRole returns the name of the deleted role.
+func (r *AuthRepository) DeleteRole(ctx context.Context, id int) (string, error) {
+	name := ""
+	err := r.db.QueryRow(ctx, "delete from e_commerce.roles where id = $1 returning name", id).Scan(&name)
+	return name, err
+}
+
+// RequirePermission has to run after Authenticate.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Error you don't have the permission to do this"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func HasPermission(c *gin.Context, permission string) bool {
+	return slices.Contains(CurrentPermissions(c), permission)
+}
+
+// holdsPermissions reports whether the current user has every one of the
+// permissions, so they can't hand out more than they have themselves.
+func holdsPermissions(c *gin.Context, permissions []string) bool {
+	for _, permission := range permissions {
+		if !HasPermission(c, permission) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// outranks reports whether the current user has every permission of the role
+// of the account, so staff can't act on accounts with more permissions than
+// their own. When they don't, or it can't be told, it responds to the request.
+func (h *AuthHandler) outranks(c *gin.Context, accountType byte) bool {
+	permissions, err := h.users.RolePermissions(c.Request.Context(), accountType)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return false
+	}
+
+	if !holdsPermissions(c, permissions) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error the account has permissions you don't have"})
+		return false
+	}
+
+	return true
+}
+
+func validPermissions(permissions []string) bool {
+	for _, permission := range permissions {
+		if !slices.Contains(AllPermissions, permission) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func stringsFromJSON(value interface{}) ([]string, bool) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	result := []string{}
+	for _, element := range list {
+		str, ok := element.(string)
+		if !ok {
+			return nil, false
+		}
+
+		result = append(result, str)
+	}
+
+	return result, true
+}
+
+func (h *AuthHandler) GetRoles(c *gin.Context) {
+	ctx := c.Request.Context()
+	roles, err := h.users.Roles(ctx)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles, "permissions": AllPermissions})
+}
+
+func (h *AuthHandler) CreateRole(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // name && permissions
+
+	name, ok := information["name"].(string)
+	if !ok || name == "" {
+		log.Println("Incorrectly provided name of the role")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided name of the role"})
+		return
+	}
+
+	permissions, ok := stringsFromJSON(information["permissions"])
+	if !ok || !validPermissions(permissions) {
+		log.Println("Incorrectly provided permissions of the role")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided permissions of the role"})
+		return
+	}
+
+	if !holdsPermissions(c, permissions) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error you can't give a role a permission you don't have"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := 0
+	err := h.users.inTx(ctx, func(tx *AuthRepository) error {
+		roleID, err := tx.CreateRole(ctx, name, permissions)
+		if err != nil {
+			return err
+		}
+		id = roleID
+
+		return audit.Record(c, tx.db, "admin.role_create", "role", id, nil, gin.H{"name": name, "permissions": permissions})
+	})
+	if err != nil {
+		if err == errTooManyRoles || err == errRoleNameTaken {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id})
+}
+
+func (h *AuthHandler) UpdateRolePermissions(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id && permissions
+
+	idFl, ok := information["id"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the role")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the role"})
+		return
+	}
+	id := int(idFl)
+
+	if id == Admin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error the permissions of the admin role can't be changed"})
+		return
+	}
+
+	permissions, ok := stringsFromJSON(information["permissions"])
+	if !ok || !validPermissions(permissions) {
+		log.Println("Incorrectly provided permissions of the role")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided permissions of the role"})
+		return
+	}
+
+	if !holdsPermissions(c, permissions) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error you can't give a role a permission you don't have"})
+		return
+	}
+
+	// The change is only kept if it makes it into the audit log.
+	ctx := c.Request.Context()
+	err := h.users.inTx(ctx, func(tx *AuthRepository) error {
+		before, err := tx.replaceRolePermissions(ctx, id, permissions)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "admin.role_update", "role", id, gin.H{"permissions": before}, gin.H{"permissions": permissions})
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no role with this id"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (h *AuthHandler) DeleteRole(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id
+
+	idFl, ok := information["id"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the role")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the role"})
+		return
+	}
+	id := int(idFl)
+
+	if id == Admin || id == User {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error the admin and user roles can't be deleted"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	users, err := h.users.countAccounts(ctx, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if users > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Error there are still accounts with this role"})
+		return
+	}
+
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		name, err := tx.DeleteRole(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "admin.role_delete", "role", id, gin.H{"name": name}, nil)
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no role with this id"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to delete the information from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
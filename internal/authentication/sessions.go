@@ -0,0 +1,184 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/gin-gonic/gin"
+)
+
+// sessionTouchInterval limits how often the last time a session was seen is
+// written, so not every request ends up writing to the database.
+const sessionTouchInterval = time.Minute
+
+// RecentLogInDuration is how long after logging in a user without a password
+// can still do what otherwise needs the password, like deleting the account.
+const RecentLogInDuration = time.Minute * 10
+
+type Session struct {
+	ID         int       `json:"id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	Current    bool      `json:"current"`
+}
+
+// StartSession records a new login of the user from the device with this ip
+// and user agent.
+func (r *AuthRepository) StartSession(ctx context.Context, userID int, ip, userAgent string) (int, error) {
+	id := 0
+	err := r.db.QueryRow(ctx, "insert into e_commerce.sessions (user_id, ip, user_agent) values ($1, $2, $3) returning id", userID, ip, userAgent).Scan(&id)
+	return id, err
+}
+
+// sessionStartedAt returns when the user logged in to the session. Refreshing
+// the tokens keeps the session, so this is the last time they proved who they
+// are on this device.
+func (r *AuthRepository) sessionStartedAt(ctx context.Context, userID, sessionID int) (time.Time, error) {
+	var startedAt time.Time
+	err := r.db.QueryRow(ctx, "select created_at from e_commerce.sessions where id = $1 and user_id = $2 and revoked_at is null", sessionID, userID).
+		Scan(&startedAt)
+	return startedAt, err
+}
+
+func (r *AuthRepository) TouchSession(ctx context.Context, sessionID int, ip string) error {
+	_, err := r.db.Exec(ctx, "update e_commerce.sessions set last_seen_at = now(), ip = $2 where id = $1 and last_seen_at < $3",
+		sessionID, ip, time.Now().Add(-sessionTouchInterval))
+	return err
+}
+
+// RevokeSession logs a device out. Its refresh tokens stop working right away
+// and so do its access tokens, since they carry the id of the session.
+func (r *AuthRepository) RevokeSession(ctx context.Context, userID, sessionID int) (bool, error) {
+	revoked := false
+	err := r.inTx(ctx, func(tx *AuthRepository) error {
+		result, err := tx.db.Exec(ctx, "update e_commerce.sessions set revoked_at = now() where id = $1 and user_id = $2 and revoked_at is null",
+			sessionID, userID)
+		if err != nil {
+			return err
+		}
+
+		// The session isn't one of the user's, so neither are its tokens.
+		if result.RowsAffected() == 0 {
+			return nil
+		}
+		revoked = true
+
+		_, err = tx.db.Exec(ctx, "update e_commerce.refresh_tokens set revoked = true where session_id = $1 and user_id = $2", sessionID, userID)
+		return err
+	})
+
+	return revoked, err
+}
+
+// Sessions returns the sessions of the user which can still be refreshed,
+// starting with the most recently used.
+func (r *AuthRepository) Sessions(ctx context.Context, userID int) ([]Session, error) {
+	rows, err := r.db.Query(ctx, "select id, ip, user_agent, created_at, last_seen_at from e_commerce.sessions where user_id = $1 and revoked_at is null "+
+		"and last_seen_at > $2 order by last_seen_at desc", userID, time.Now().Add(-RefreshTokenDuration))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session := Session{}
+		if err = rows.Scan(&session.ID, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt); err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (r *AuthRepository) RevokeOtherSessions(ctx context.Context, userID, sessionID int) error {
+	return r.inTx(ctx, func(tx *AuthRepository) error {
+		_, err := tx.db.Exec(ctx, "update e_commerce.refresh_tokens set revoked = true where user_id = $1 and session_id is distinct from $2", userID, sessionID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.db.Exec(ctx, "update e_commerce.sessions set revoked_at = now() where user_id = $1 and id != $2 and revoked_at is null", userID, sessionID)
+		return err
+	})
+}
+
+func (h *AuthHandler) GetSessions(c *gin.Context) {
+	ctx := c.Request.Context()
+	sessions, err := h.users.Sessions(ctx, CurrentUserID(c))
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == CurrentSessionID(c)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+func (h *AuthHandler) DeleteSession(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id
+
+	id := CurrentUserID(c)
+
+	sessionIDFl, ok := information["id"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the session")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the session"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	revoked := false
+	err := h.users.inTx(ctx, func(tx *AuthRepository) error {
+		var err error
+		if revoked, err = tx.RevokeSession(ctx, id, int(sessionIDFl)); err != nil || !revoked {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "auth.session_revoke", "session", int(sessionIDFl), nil, nil)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to revoke the session"})
+		return
+	}
+
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no active session with this id"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+// DeleteOtherSessions logs the user out everywhere except on this device.
+func (h *AuthHandler) DeleteOtherSessions(c *gin.Context) {
+	ctx := c.Request.Context()
+	err := h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.RevokeOtherSessions(ctx, CurrentUserID(c), CurrentSessionID(c)); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "auth.session_revoke_others", "user", CurrentUserID(c), nil, nil)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to revoke the sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
@@ -0,0 +1,357 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	AccessTokenDuration  = time.Minute * 15
+	RefreshTokenDuration = time.Hour * 24 * 30
+)
+
+func randomToken(size int) (string, error) {
+	bytes := make([]byte, size)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *AuthRepository) CreateRefreshToken(ctx context.Context, userID, sessionID int) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = r.db.Exec(ctx, "insert into e_commerce.refresh_tokens (user_id, session_id, token_hash, expires_at) values ($1, $2, $3, $4)",
+		userID, sessionID, hashToken(token), time.Now().Add(RefreshTokenDuration))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+type refreshTokenInfo struct {
+	ID     int
+	UserID int
+	// SessionID is nil for the tokens from before sessions were recorded.
+	SessionID *int
+	Revoked   bool
+	// RotatedAt is when the token was exchanged for a new one, nil if it was
+	// revoked for another reason or not at all.
+	RotatedAt *time.Time
+	ExpiresAt time.Time
+}
+
+func (r *AuthRepository) refreshToken(ctx context.Context, tokenHash string) (*refreshTokenInfo, error) {
+	token := &refreshTokenInfo{}
+	err := r.db.QueryRow(ctx, "select id, user_id, session_id, revoked, rotated_at, expires_at from e_commerce.refresh_tokens where token_hash = $1", tokenHash).
+		Scan(&token.ID, &token.UserID, &token.SessionID, &token.Revoked, &token.RotatedAt, &token.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// rotateRefreshToken revokes a token which is being exchanged for a new one.
+// It reports false if the token was already revoked, which happens when two
+// requests try to use it at the same time.
+func (r *AuthRepository) rotateRefreshToken(ctx context.Context, id int) (bool, error) {
+	result, err := r.db.Exec(ctx, "update e_commerce.refresh_tokens set revoked = true, rotated_at = now() where id = $1 and not revoked", id)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+// IssueTokens starts a new session and creates an access token and refresh
+// token pair for a user that has just proven who they are.
+func (h *AuthHandler) IssueTokens(c *gin.Context, id int, accountType byte, email string) (string, string, error) {
+	return h.users.issueTokens(c.Request.Context(), id, accountType, email, c.ClientIP(), c.Request.UserAgent())
+}
+
+func (r *AuthRepository) issueTokens(ctx context.Context, id int, accountType byte, email, ip, userAgent string) (string, string, error) {
+	sessionID, err := r.StartSession(ctx, id, ip, userAgent)
+	if err != nil {
+		return "", "", err
+	}
+
+	return r.issueSessionTokens(ctx, sessionID, id, accountType, email)
+}
+
+func (r *AuthRepository) issueSessionTokens(ctx context.Context, sessionID, id int, accountType byte, email string) (string, string, error) {
+	permissions, err := r.RolePermissions(ctx, accountType)
+	if err != nil {
+		return "", "", err
+	}
+
+	jwtToken, err := GenerateJWT(id, sessionID, accountType, email, permissions)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := r.CreateRefreshToken(ctx, id, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return jwtToken, refreshToken, nil
+}
+
+// TokenRevoked reports whether an access token was revoked on its own, was
+// issued before all of the user's tokens were revoked, belongs to a session
+// that was ended or to a user that is suspended.
+func (r *AuthRepository) TokenRevoked(ctx context.Context, jti string, userID, sessionID int, issuedAt float64) (bool, error) {
+	revoked := false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from e_commerce.revoked_tokens where jti = $1) or "+
+		"exists (select 1 from e_commerce.authentication where id = $2 and (suspended_at is not null or (tokens_revoked_at is not null and $3 <= extract(epoch from tokens_revoked_at)))) or "+
+		"exists (select 1 from e_commerce.sessions where id = $4 and revoked_at is not null)",
+		jti, userID, issuedAt, sessionID).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+func (r *AuthRepository) RevokeAccessToken(ctx context.Context, jti string, expiration int64) error {
+	_, err := r.db.Exec(ctx, "delete from e_commerce.revoked_tokens where expires_at < now()")
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, "insert into e_commerce.revoked_tokens (jti, expires_at) values ($1, $2) on conflict do nothing", jti, time.Unix(expiration, 0))
+	return err
+}
+
+func (r *AuthRepository) RevokeAllTokens(ctx context.Context, userID int) error {
+	_, err := r.db.Exec(ctx, "update e_commerce.refresh_tokens set revoked = true where user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, "update e_commerce.sessions set revoked_at = now() where user_id = $1 and revoked_at is null", userID)
+	if err != nil {
+		return err
+	}
+
+	// The cutoff is compared to the issue time of the tokens, which comes from
+	// the clock of the server and not the one of the database.
+	_, err = r.db.Exec(ctx, "update e_commerce.authentication set tokens_revoked_at = $2 where id = $1", userID, time.Now())
+	return err
+}
+
+// refreshTokenReused ends every session of the user, since a rotated refresh
+// token being used again means it was stolen. It responds to the request.
+func (h *AuthHandler) refreshTokenReused(c *gin.Context, userID int) {
+	log.Println("Reuse of a rotated refresh token for user", userID)
+	ctx := c.Request.Context()
+	err := h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.RevokeAllTokens(ctx, userID); err != nil {
+			return err
+		}
+
+		return audit.RecordAs(c, tx.db, 0, "auth.refresh_token_reuse", "user", userID, nil, nil)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to revoke your tokens"})
+		return
+	}
+
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid refresh token"})
+}
+
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // refreshToken
+
+	refreshToken, ok := information["refreshToken"]
+	if !ok {
+		log.Println("Incorrectly provided refresh token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided refresh token"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	token, err := h.users.refreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid refresh token"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+	userID := token.UserID
+
+	if token.Revoked {
+		if token.RotatedAt != nil {
+			h.refreshTokenReused(c, userID)
+			return
+		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid refresh token"})
+		return
+	}
+
+	if token.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error refresh token has expired"})
+		return
+	}
+
+	profile, err := h.users.Profile(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if profile.Suspended {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error your account has been suspended"})
+		return
+	}
+
+	if profile.Type == Admin {
+		enabled, err := h.users.TwoFactorEnabled(ctx, userID)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+			return
+		}
+
+		if !enabled {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Error admins have to set up two-factor authentication, log in again"})
+			return
+		}
+	}
+
+	rotated, err := h.users.rotateRefreshToken(ctx, token.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	if !rotated {
+		h.refreshTokenReused(c, userID)
+		return
+	}
+
+	// Refresh tokens from before sessions were recorded get a new session.
+	sessionID := token.SessionID
+	if sessionID == nil {
+		id, err := h.users.StartSession(ctx, userID, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information in the database"})
+			return
+		}
+
+		sessionID = &id
+	}
+
+	if err = h.users.TouchSession(ctx, *sessionID, c.ClientIP()); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	jwtToken, newRefreshToken, err := h.users.issueSessionTokens(ctx, *sessionID, userID, profile.Type, profile.Email)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while generating your token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": jwtToken, "refreshToken": newRefreshToken})
+}
+
+func (h *AuthHandler) LogOut(c *gin.Context) {
+	id := CurrentUserID(c)
+
+	jti, expiration := CurrentTokenID(c)
+
+	ctx := c.Request.Context()
+	err := h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.RevokeAccessToken(ctx, jti, expiration); err != nil {
+			return err
+		}
+
+		if _, err := tx.RevokeSession(ctx, id, CurrentSessionID(c)); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "auth.logout", "session", CurrentSessionID(c), nil, nil)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to end your session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (h *AuthHandler) ForceLogOut(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // userID
+
+	userIDFl, ok := information["userID"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the user")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the user"})
+		return
+	}
+	userID := int(userIDFl)
+
+	ctx := c.Request.Context()
+	exists, err := h.users.Exists(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no user with this id"})
+		return
+	}
+
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.RevokeAllTokens(ctx, userID); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "admin.force_logout", "user", userID, nil, nil)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to revoke the tokens of the user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
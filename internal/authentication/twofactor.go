@@ -0,0 +1,697 @@
+package authentication
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	TwoFactorChallengeDuration = time.Minute * 5
+	maxTwoFactorAttempts       = 5
+	recoveryCodesCount         = 10
+	totpIssuer                 = "E-commerce"
+	totpPeriod                 = 30
+	totpDigits                 = 6
+	totpSkew                   = 1
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("Error two-factor authentication is already enabled")
+	ErrTwoFactorNotSetUp       = errors.New("Error two-factor authentication hasn't been set up")
+	ErrInvalidTwoFactorCode    = errors.New("Error invalid two-factor code")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode computes the code of the given time step as described in RFC 6238,
+// using HMAC-SHA1 like every authenticator app does by default.
+func totpCode(key []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP returns the time step the code belongs to. One step of clock drift
+// is allowed either way and steps that were already used are rejected, so a
+// code can't be replayed.
+func matchTOTP(secret, code string, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	current := time.Now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func provisioningURI(secret, email string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", totpIssuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+email) + "?" + values.Encode()
+}
+
+func (r *AuthRepository) TwoFactorEnabled(ctx context.Context, userID int) (bool, error) {
+	enabled := false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from e_commerce.two_factor where user_id = $1 and enabled)", userID).Scan(&enabled)
+	return enabled, err
+}
+
+// setUpTwoFactor creates a new secret for the user, which is only used after
+// it's confirmed with a code from the authenticator app.
+func (r *AuthRepository) setUpTwoFactor(ctx context.Context, userID int) (string, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	secret := totpEncoding.EncodeToString(key)
+	result, err := r.db.Exec(ctx, "insert into e_commerce.two_factor (user_id, secret) values ($1, $2) on conflict (user_id) do update "+
+		"set secret = excluded.secret, last_step = 0, created_at = now() where not e_commerce.two_factor.enabled", userID, secret)
+	if err != nil {
+		return "", err
+	}
+
+	if result.RowsAffected() == 0 {
+		return "", ErrTwoFactorAlreadyEnabled
+	}
+
+	return secret, nil
+}
+
+func (r *AuthRepository) checkTOTP(ctx context.Context, userID int, code string) error {
+	var secret string
+	var lastStep int64
+	err := r.db.QueryRow(ctx, "select secret, last_step from e_commerce.two_factor where user_id = $1", userID).Scan(&secret, &lastStep)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrTwoFactorNotSetUp
+		}
+
+		return err
+	}
+
+	step, ok := matchTOTP(secret, strings.TrimSpace(code), lastStep)
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	result, err := r.db.Exec(ctx, "update e_commerce.two_factor set last_step = $1 where user_id = $2 and last_step < $1", step, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) useRecoveryCode(ctx context.Context, userID int, code string) error {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	result, err := r.db.Exec(ctx, "update e_commerce.recovery_codes set used = true where user_id = $1 and code_hash = $2 and not used",
+		userID, hashToken(code))
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// checkSecondFactor accepts either a code from the authenticator app or one of
+// the recovery codes of the user.
+func (r *AuthRepository) checkSecondFactor(ctx context.Context, userID int, information map[string]string) error {
+	if code, ok := information["code"]; ok {
+		return r.checkTOTP(ctx, userID, code)
+	}
+
+	if code, ok := information["recoveryCode"]; ok {
+		return r.useRecoveryCode(ctx, userID, code)
+	}
+
+	return ErrInvalidTwoFactorCode
+}
+
+// generateRecoveryCodes replaces the recovery codes of the user. Only their
+// hashes are stored, so they are shown to the user just this once.
+func (r *AuthRepository) generateRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	codes := []string{}
+	err := r.inTx(ctx, func(tx *AuthRepository) error {
+		_, err := tx.db.Exec(ctx, "delete from e_commerce.recovery_codes where user_id = $1", userID)
+		if err != nil {
+			return err
+		}
+
+		for range recoveryCodesCount {
+			code, err := randomToken(5)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.db.Exec(ctx, "insert into e_commerce.recovery_codes (user_id, code_hash) values ($1, $2)", userID, hashToken(code))
+			if err != nil {
+				return err
+			}
+
+			codes = append(codes, code[:5]+"-"+code[5:])
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// enableTwoFactor turns two-factor authentication on together with creating
+// the recovery codes, so the user can't end up with it on and no way back in.
+func (r *AuthRepository) enableTwoFactor(ctx context.Context, userID int) ([]string, error) {
+	var codes []string
+	err := r.inTx(ctx, func(tx *AuthRepository) error {
+		_, err := tx.db.Exec(ctx, "update e_commerce.two_factor set enabled = true where user_id = $1", userID)
+		if err != nil {
+			return err
+		}
+
+		codes, err = tx.generateRecoveryCodes(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (r *AuthRepository) createTwoFactorChallenge(ctx context.Context, userID int) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = r.db.Exec(ctx, "insert into e_commerce.two_factor_challenges (user_id, token_hash, expires_at) values ($1, $2, $3)",
+		userID, hashToken(token), time.Now().Add(TwoFactorChallengeDuration))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// twoFactorChallengeUser returns the user a login challenge belongs to. Every
+// attempt to answer it is counted, so the codes can't be guessed.
+func (r *AuthRepository) twoFactorChallengeUser(ctx context.Context, token string) (int, error) {
+	userID := 0
+	err := r.db.QueryRow(ctx, "update e_commerce.two_factor_challenges set attempts = attempts + 1 where token_hash = $1 and not used "+
+		"and expires_at > now() and attempts < $2 returning user_id", hashToken(token), maxTwoFactorAttempts).Scan(&userID)
+	return userID, err
+}
+
+func (r *AuthRepository) useTwoFactorChallenge(ctx context.Context, token string) error {
+	_, err := r.db.Exec(ctx, "update e_commerce.two_factor_challenges set used = true where token_hash = $1", hashToken(token))
+	return err
+}
+
+func (r *AuthRepository) disableTwoFactor(ctx context.Context, userID int) error {
+	return r.inTx(ctx, func(tx *AuthRepository) error {
+		_, err := tx.db.Exec(ctx, "delete from e_commerce.recovery_codes where user_id = $1", userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.db.Exec(ctx, "delete from e_commerce.two_factor where user_id = $1", userID)
+		return err
+	})
+}
+
+// completeLogIn is called once the user has proven who they are with their
+// first factor. The tokens are issued right away unless a second factor is
+// needed, in which case the user gets a challenge to answer at /login/2fa.
+// Admins have to use two-factor authentication, so they are asked to set it
+// up if they haven't yet. The method the user logged in with goes into the
+// audit log.
+func (h *AuthHandler) completeLogIn(c *gin.Context, id int, accountType byte, email, method string) {
+	ctx := c.Request.Context()
+	suspended, err := h.users.IsSuspended(ctx, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	if suspended {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error your account has been suspended"})
+		return
+	}
+
+	enabled, err := h.users.TwoFactorEnabled(ctx, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	if !enabled && accountType != Admin {
+		var jwtToken, refreshToken string
+		err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+			var err error
+			jwtToken, refreshToken, err = tx.issueTokens(ctx, id, accountType, email, c.ClientIP(), c.Request.UserAgent())
+			if err != nil {
+				return err
+			}
+
+			return audit.RecordAs(c, tx.db, id, "auth.login", "user", id, nil, gin.H{"method": method})
+		})
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while generating your token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"token": jwtToken, "refreshToken": refreshToken})
+		return
+	}
+
+	challenge := ""
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		var err error
+		challenge, err = tx.createTwoFactorChallenge(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return audit.RecordAs(c, tx.db, id, "auth.login_challenge", "user", id, nil, gin.H{"method": method})
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	if !enabled {
+		c.JSON(http.StatusOK, gin.H{"twoFactorSetupRequired": true, "twoFactorToken": challenge})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"twoFactorRequired": true, "twoFactorToken": challenge})
+}
+
+func (h *AuthHandler) LogInTwoFactor(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // twoFactorToken && (code || recoveryCode)
+
+	token, ok := information["twoFactorToken"]
+	if !ok {
+		log.Println("Incorrectly provided two-factor token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided two-factor token"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID, err := h.users.twoFactorChallengeUser(ctx, token)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Error the two-factor token is invalid or has expired, log in again"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	profile, err := h.users.activeAccount(ctx, userID)
+	if err != nil {
+		// The account was deleted after the challenge was made.
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Error the two-factor token is invalid or has expired, log in again"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	enabled, err := h.users.TwoFactorEnabled(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	// Admins setting up two-factor authentication confirm the new secret here
+	// and only then get their tokens.
+	if enabled {
+		err = h.users.checkSecondFactor(ctx, userID, information)
+	} else {
+		err = h.users.checkTOTP(ctx, userID, information["code"])
+	}
+
+	if err != nil {
+		if err == ErrInvalidTwoFactorCode || err == ErrTwoFactorNotSetUp {
+			if err := audit.RecordAs(c, h.db, userID, "auth.login_2fa_failed", "user", userID, nil, nil); err != nil {
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+				return
+			}
+
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to check the two-factor code"})
+		return
+	}
+
+	var recoveryCodes []string
+	var jwtToken, refreshToken string
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		var err error
+		if !enabled {
+			recoveryCodes, err = tx.enableTwoFactor(ctx, userID)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err = tx.useTwoFactorChallenge(ctx, token); err != nil {
+			return err
+		}
+
+		jwtToken, refreshToken, err = tx.issueTokens(ctx, userID, profile.Type, profile.Email, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			return err
+		}
+
+		return audit.RecordAs(c, tx.db, userID, "auth.login", "user", userID, nil, gin.H{"method": "2fa"})
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	if recoveryCodes != nil {
+		c.JSON(http.StatusOK, gin.H{"token": jwtToken, "refreshToken": refreshToken, "recoveryCodes": recoveryCodes})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": jwtToken, "refreshToken": refreshToken})
+}
+
+// SetUpTwoFactorAtLogIn lets admins who don't have two-factor authentication
+// yet set it up with the challenge they got from logging in.
+func (h *AuthHandler) SetUpTwoFactorAtLogIn(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // twoFactorToken
+
+	token, ok := information["twoFactorToken"]
+	if !ok {
+		log.Println("Incorrectly provided two-factor token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided two-factor token"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID, err := h.users.twoFactorChallengeUser(ctx, token)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Error the two-factor token is invalid or has expired, log in again"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while trying to log in"})
+		return
+	}
+
+	profile, err := h.users.Profile(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	secret, err := h.users.setUpTwoFactor(ctx, userID)
+	if err != nil {
+		if err == ErrTwoFactorAlreadyEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to set up two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "uri": provisioningURI(secret, profile.Email)})
+}
+
+func (h *AuthHandler) SetUpTwoFactor(c *gin.Context) {
+	id := CurrentUserID(c)
+
+	ctx := c.Request.Context()
+	secret, err := h.users.setUpTwoFactor(ctx, id)
+	if err != nil {
+		if err == ErrTwoFactorAlreadyEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to set up two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "uri": provisioningURI(secret, CurrentEmail(c))})
+}
+
+func (h *AuthHandler) EnableTwoFactor(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // code
+
+	id := CurrentUserID(c)
+
+	code, ok := information["code"]
+	if !ok {
+		log.Println("Incorrectly provided code")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided code"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	enabled, err := h.users.TwoFactorEnabled(ctx, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": ErrTwoFactorAlreadyEnabled.Error()})
+		return
+	}
+
+	if err = h.users.checkTOTP(ctx, id, code); err != nil {
+		if err == ErrInvalidTwoFactorCode || err == ErrTwoFactorNotSetUp {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to check the two-factor code"})
+		return
+	}
+
+	var recoveryCodes []string
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		var err error
+		recoveryCodes, err = tx.enableTwoFactor(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "auth.2fa_enable", "user", id, gin.H{"twoFactor": false}, gin.H{"twoFactor": true})
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to enable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": recoveryCodes})
+}
+
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // password? && (code || recoveryCode)
+
+	id := CurrentUserID(c)
+
+	if CurrentAccountType(c) == Admin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error two-factor authentication is mandatory for admins"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	passwordCheck, err := h.users.passwordHash(ctx, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	// An account without a password only proves who it is with the second
+	// factor.
+	if passwordCheck == "" {
+		if !h.reauthenticated(c, id, information) {
+			return
+		}
+	} else {
+		password, ok := information["password"]
+		if !ok {
+			log.Println("Incorrectly provided password")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided password"})
+			return
+		}
+
+		match, _, err := VerifyPassword(password, passwordCheck)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to check your password"})
+			return
+		}
+
+		if !match {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Error wrong password"})
+			return
+		}
+
+		if err = h.users.checkSecondFactor(ctx, id, information); err != nil {
+			if err == ErrInvalidTwoFactorCode || err == ErrTwoFactorNotSetUp {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to check the two-factor code"})
+			return
+		}
+	}
+
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.disableTwoFactor(ctx, id); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "auth.2fa_disable", "user", id, gin.H{"twoFactor": true}, gin.H{"twoFactor": false})
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // code
+
+	id := CurrentUserID(c)
+
+	code, ok := information["code"]
+	if !ok {
+		log.Println("Incorrectly provided code")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided code"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	enabled, err := h.users.TwoFactorEnabled(ctx, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if !enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Error two-factor authentication isn't enabled"})
+		return
+	}
+
+	if err = h.users.checkTOTP(ctx, id, code); err != nil {
+		if err == ErrInvalidTwoFactorCode || err == ErrTwoFactorNotSetUp {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to check the two-factor code"})
+		return
+	}
+
+	recoveryCodes := []string{}
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		var err error
+		if recoveryCodes, err = tx.generateRecoveryCodes(ctx, id); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "auth.recovery_codes_regenerate", "user", id, nil, nil)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to create new recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": recoveryCodes})
+}
@@ -0,0 +1,66 @@
+package authentication
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA1 vectors of RFC 6238, appendix B, cut to the six digits the shop uses.
+func TestTOTPCode(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		if code := totpCode(key, test.time/totpPeriod); code != test.code {
+			t.Errorf("totpCode at %d = %s, want %s", test.time, code, test.code)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := totpEncoding.EncodeToString(key)
+	// matchTOTP reads the clock again, so the test doesn't start right before
+	// the step changes.
+	if time.Now().Unix()%totpPeriod == totpPeriod-1 {
+		time.Sleep(time.Second)
+	}
+	current := time.Now().Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastStep int64
+		step     int64
+		match    bool
+	}{
+		{"current step", secret, totpCode(key, current), 0, current, true},
+		{"previous step", secret, totpCode(key, current-1), 0, current - 1, true},
+		{"next step", secret, totpCode(key, current+1), 0, current + 1, true},
+		{"too old", secret, totpCode(key, current-2), 0, 0, false},
+		{"too far ahead", secret, totpCode(key, current+2), 0, 0, false},
+		{"already used", secret, totpCode(key, current), current, 0, false},
+		{"wrong code", secret, "0000000", 0, 0, false},
+		{"invalid secret", "not base32!", totpCode(key, current), 0, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, match := matchTOTP(test.secret, test.code, test.lastStep)
+			if match != test.match || step != test.step {
+				t.Errorf("matchTOTP() = %d, %v, want %d, %v", step, match, test.step, test.match)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	ImpersonationDuration = time.Minute * 15
+	defaultPageSize       = 20
+	maxPageSize           = 100
+)
+
+func parseDate(value string) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// pagination reads the page and pageSize query parameters, pages start at 1.
+func pagination(c *gin.Context) (int, int, bool) {
+	page, pageSize := 1, defaultPageSize
+	if value := c.Query("page"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 {
+			return 0, 0, false
+		}
+
+		page = number
+	}
+
+	if value := c.Query("pageSize"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 || number > maxPageSize {
+			return 0, 0, false
+		}
+
+		pageSize = number
+	}
+
+	return page, pageSize, true
+}
+
+func (r *AuthRepository) IsSuspended(ctx context.Context, userID int) (bool, error) {
+	suspended := false
+	err := r.db.QueryRow(ctx, "select suspended_at is not null from e_commerce.authentication where id = $1", userID).Scan(&suspended)
+	return suspended, err
+}
+
+// activeAccount returns the email and the type of an account which isn't
+// deleted.
+func (r *AuthRepository) activeAccount(ctx context.Context, userID int) (*Profile, error) {
+	profile := &Profile{ID: userID}
+	err := r.db.QueryRow(ctx, "select email, type from e_commerce.authentication where id = $1 and deleted_at is null", userID).Scan(&profile.Email, &profile.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+func (r *AuthRepository) setSuspended(ctx context.Context, userID int, suspend bool) error {
+	if !suspend {
+		_, err := r.db.Exec(ctx, "update e_commerce.authentication set suspended_at = null where id = $1", userID)
+		return err
+	}
+
+	_, err := r.db.Exec(ctx, "update e_commerce.authentication set suspended_at = now() where id = $1 and suspended_at is null", userID)
+	return err
+}
+
+func (r *AuthRepository) recordImpersonation(ctx context.Context, claims *Claims, reason, ip string) error {
+	_, err := r.db.Exec(ctx, "insert into e_commerce.impersonations (impersonator_id, user_id, reason, jti, ip, expires_at) values ($1, $2, $3, $4, $5, $6)",
+		claims.Impersonator, claims.UserID(), reason, claims.ID, ip, claims.ExpiresAt.Time)
+	return err
+}
+
+func (h *AuthHandler) setSuspended(c *gin.Context, suspend bool) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // userID
+
+	userIDFl, ok := information["userID"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the user")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the user"})
+		return
+	}
+	userID := int(userIDFl)
+
+	if suspend && userID == CurrentUserID(c) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error you can't suspend yourself"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	account, err := h.users.activeAccount(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no user with this id"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if !h.outranks(c, account.Type) {
+		return
+	}
+
+	if !suspend {
+		err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+			if err := tx.setSuspended(ctx, userID, false); err != nil {
+				return err
+			}
+
+			return audit.Record(c, tx.db, "admin.unsuspend", "user", userID, gin.H{"suspended": true}, gin.H{"suspended": false})
+		})
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, nil)
+		return
+	}
+
+	if account.Type == Admin {
+		c.JSON(http.StatusConflict, gin.H{"error": "Error admins can't be suspended, demote them first"})
+		return
+	}
+
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.setSuspended(ctx, userID, true); err != nil {
+			return err
+		}
+
+		if err := tx.RevokeAllTokens(ctx, userID); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "admin.suspend", "user", userID, gin.H{"suspended": false}, gin.H{"suspended": true})
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+// RequireOwner keeps impersonation tokens away from the personal data of the
+// user, like their profile, their sessions and the export of their data.
+// Staff only need to see the shop the way the customer sees it.
+func RequireOwner(c *gin.Context) {
+	if CurrentImpersonatorID(c) != 0 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Error this can't be seen while impersonating a user"})
+		return
+	}
+
+	c.Next()
+}
+
+// SuspendUser logs the user out everywhere and keeps them from logging in
+// until they are unsuspended.
+func (h *AuthHandler) SuspendUser(c *gin.Context) {
+	h.setSuspended(c, true)
+}
+
+func (h *AuthHandler) UnsuspendUser(c *gin.Context) {
+	h.setSuspended(c, false)
+}
+
+// ImpersonateUser gives staff a short-lived, read-only token of a customer, so
+// they can see exactly what the customer sees. Every use is recorded along
+// with the reason for it.
+func (h *AuthHandler) ImpersonateUser(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // userID && reason
+
+	userIDFl, ok := information["userID"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the user")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the user"})
+		return
+	}
+	userID := int(userIDFl)
+
+	reason, ok := information["reason"].(string)
+	if !ok || reason == "" {
+		log.Println("Incorrectly provided reason")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error a reason for the impersonation is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	account, err := h.users.activeAccount(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no user with this id"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if account.Type == Admin || userID == CurrentUserID(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error you can't impersonate this user"})
+		return
+	}
+
+	if !h.outranks(c, account.Type) {
+		return
+	}
+
+	// The token has no permissions, so even the staff-only routes of the
+	// customer stay closed.
+	claims, err := newClaims(userID, account.Type, account.Email, []string{}, ImpersonationDuration)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while generating the token"})
+		return
+	}
+
+	claims.Impersonator = CurrentUserID(c)
+
+	token, err := Keys.Sign(claims)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while generating the token"})
+		return
+	}
+
+	// The token is only handed out if the impersonation is recorded.
+	err = h.users.inTx(ctx, func(tx *AuthRepository) error {
+		if err := tx.recordImpersonation(ctx, claims, reason, c.ClientIP()); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "admin.impersonate", "user", userID, nil, gin.H{"reason": reason, "expiresAt": claims.ExpiresAt.Time})
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "expiresAt": claims.ExpiresAt.Time})
+}
@@ -0,0 +1,169 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/emails"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	VerificationTokenDuration = time.Hour * 24
+	verificationResendDelay   = time.Minute
+	verificationHourlyLimit   = 5
+)
+
+var (
+	ErrTooManyVerificationEmails = errors.New("Error too many verification emails were requested, try again later")
+	ErrEmailTaken                = errors.New("Error there is already a person with this email")
+	errEmailChanged              = errors.New("Error the email of this account has changed since the link was sent")
+)
+
+func (r *AuthRepository) IsVerified(ctx context.Context, userID int) (bool, error) {
+	verified := false
+	err := r.db.QueryRow(ctx, "select verified from e_commerce.authentication where id = $1", userID).Scan(&verified)
+	return verified, err
+}
+
+// verificationsSent returns when the last verification email was sent to the
+// user and how many were sent in the last hour.
+func (r *AuthRepository) verificationsSent(ctx context.Context, userID int) (*time.Time, int, error) {
+	var lastSent *time.Time
+	sentLastHour := 0
+	err := r.db.QueryRow(ctx, "select max(created_at), count(*) filter (where created_at > now() - interval '1 hour') "+
+		"from e_commerce.email_verifications where user_id = $1", userID).Scan(&lastSent, &sentLastHour)
+	return lastSent, sentLastHour, err
+}
+
+func (r *AuthRepository) createVerification(ctx context.Context, userID int, email, tokenHash string) error {
+	_, err := r.db.Exec(ctx, "insert into e_commerce.email_verifications (user_id, email, token_hash, expires_at) values ($1, $2, $3, $4)",
+		userID, email, tokenHash, time.Now().Add(VerificationTokenDuration))
+	return err
+}
+
+// VerifyEmail uses up the verification token and marks the email it was sent
+// to as verified. It returns pgx.ErrNoRows if the token is invalid or expired.
+func (r *AuthRepository) VerifyEmail(ctx context.Context, tokenHash string) error {
+	return r.inTx(ctx, func(tx *AuthRepository) error {
+		userID := 0
+		email := ""
+		err := tx.db.QueryRow(ctx, "update e_commerce.email_verifications set used = true where token_hash = $1 and used = false and expires_at > now() "+
+			"returning user_id, email", tokenHash).Scan(&userID, &email)
+		if err != nil {
+			return err
+		}
+
+		taken, err := tx.emailTaken(ctx, email, userID)
+		if err != nil {
+			return err
+		}
+
+		if taken {
+			return ErrEmailTaken
+		}
+
+		// The link either confirms the current email of the account or a new one
+		// it is being changed to. The email is part of the tokens, so they are
+		// revoked when it changes.
+		result, err := tx.db.Exec(ctx, "update e_commerce.authentication set email = $2, verified = true, pending_email = null, "+
+			"tokens_revoked_at = case when email != $2 then $3 else tokens_revoked_at end "+
+			"where id = $1 and (email = $2 or pending_email = $2) and deleted_at is null", userID, email, time.Now())
+		if err != nil {
+			return err
+		}
+
+		if result.RowsAffected() == 0 {
+			return errEmailChanged
+		}
+
+		return nil
+	})
+}
+
+// sendVerificationEmail emails a one-time link which proves that the user
+// owns the given email address once it's opened.
+func (h *AuthHandler) sendVerificationEmail(ctx context.Context, userID int, email string) error {
+	lastSent, sentLastHour, err := h.users.verificationsSent(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if (lastSent != nil && time.Since(*lastSent) < verificationResendDelay) || sentLastHour >= verificationHourlyLimit {
+		return ErrTooManyVerificationEmails
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	if err = h.users.createVerification(ctx, userID, email, hashToken(token)); err != nil {
+		return err
+	}
+
+	link := os.Getenv("APP_URL") + "/email/verify?token=" + url.QueryEscape(token)
+	return emails.Send(ctx, h.db, email, "Verify your email", "Open the following link to verify your email address:\n\n"+link+
+		"\n\nThe link expires in 24 hours. If you didn't request this, you can ignore this email.")
+}
+
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		log.Println("Incorrectly provided verification token")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided verification token"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := h.users.VerifyEmail(ctx, hashToken(token)); err != nil {
+		switch err {
+		case pgx.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error the verification link is invalid or has expired"})
+		case ErrEmailTaken, errEmailChanged:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to verify your email"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (h *AuthHandler) ResendVerificationEmail(c *gin.Context) {
+	id := CurrentUserID(c)
+
+	ctx := c.Request.Context()
+	profile, err := h.users.Profile(ctx, id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if profile.Verified {
+		c.JSON(http.StatusConflict, gin.H{"error": "Error your email is already verified"})
+		return
+	}
+
+	if err = h.sendVerificationEmail(ctx, id, profile.Email); err != nil {
+		if err == ErrTooManyVerificationEmails {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to send the verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
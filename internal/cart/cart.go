@@ -4,22 +4,37 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	. "github.com/Phantomvv1/E-commerce/internal/audit"
 	. "github.com/Phantomvv1/E-commerce/internal/authentication"
-	. "github.com/Phantomvv1/E-commerce/internal/comparison"
+	"github.com/Phantomvv1/E-commerce/internal/database"
 	. "github.com/Phantomvv1/E-commerce/internal/items"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
 	"github.com/stripe/stripe-go/v82"
 	"github.com/stripe/stripe-go/v82/customer"
 	"github.com/stripe/stripe-go/v82/paymentintent"
+	"github.com/stripe/stripe-go/v82/webhook"
 )
 
+var (
+	ErrEmptyCart     = errors.New("There are no items in your cart")
+	ErrNoValidCoupon = errors.New("Error there is no valid coupon for this user")
+)
+
+// ReservationDuration is how long the stock of a checkout is held for while
+// it's being paid for.
+const ReservationDuration = 15 * time.Minute
+
+// maxWebhookSize is the most of an event from Stripe which is read.
+const maxWebhookSize = 64 << 10
+
 type Cart struct {
 	Items []Item `json:"items"`
 }
@@ -32,149 +47,29 @@ type Coupon struct {
 }
 
 type CartItem struct {
-	Item     Item `json:"item"`
-	Quantity int  `json:"quantity"`
-}
-
-func (c Coupon) IsValid(conn *pgx.Conn) bool {
-	if c.ExpirationDate.Unix() < time.Now().Unix() {
-		return false
-	} else if c.Discount > 100 {
-		return false
-	}
-
-	id := 0
-	err := conn.QueryRow(context.Background(), "select id, used from e_commerce.coupons c where c.number = $1", c.CouponNumber).Scan(&id)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return true
-		}
-
-		log.Println(err)
-		return false
-	}
-
-	return false
-}
-
-func (c *Coupon) GetCoupon(conn *pgx.Conn, userID int) error {
-	err := conn.QueryRow(context.Background(), "select id, exp_date, discount, number from e_commerce.coupons c where c.used = false and c.exp_date > current_date and user_id = $1", userID).
-		Scan(&c.ID, &c.ExpirationDate, &c.Discount, &c.CouponNumber)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return errors.New("Error there is no valid coupon for this user")
-		}
-
-		log.Println(err)
-		return errors.New("Error unable to get the coupon from the database")
-	}
-
-	if c.ExpirationDate.Unix() < time.Now().Unix() {
-		return errors.New("Error invalid coupon")
-	}
-
-	return nil
+	Item     Item    `json:"item"`
+	Variant  Variant `json:"variant"`
+	Quantity int     `json:"quantity"`
 }
 
-func givePurchasePoints(conn *pgx.Conn, purchasePoints, id int) error {
-	_, err := conn.Exec(context.Background(), "update e_commerce.authentication set points = points + $1 where id = $2", purchasePoints, id)
-	return err
-}
-
-func CreateCouponsTable(conn *pgx.Conn) error {
-	_, err := conn.Exec(context.Background(), "create table if not exists e_commerce.coupons (id serial primary key, user_id int references e_commerce.authentication(id) on delete cascade, "+
-		"exp_date date, discount int, number int, used boolean)")
-	return err
-}
-
-func getCartPrice(conn *pgx.Conn, userId int) (float32, error) {
-	rows, err := conn.Query(context.Background(), "select c.item_id, quantity from e_commerce.cart c where user_id = $1 order by c.item_id", userId)
-	if err != nil {
-		return 0.0, err
-	}
-
-	var itemIDs []interface{}
-	var quantities []int
-	for rows.Next() {
-		itemID := 0
-		quantity := 0
-		err = rows.Scan(&itemID, &quantity)
-		if err != nil {
-			return 0.0, err
-		}
-
-		itemIDs = append(itemIDs, itemID)
-		quantities = append(quantities, quantity)
-	}
-
-	if rows.Err() != nil {
-		return 0.0, rows.Err()
-	}
-
-	query := "select i.price from e_commerce.items i where i.id in ("
-	for i := range itemIDs {
-		if i == len(itemIDs)-1 {
-			query += "$" + fmt.Sprintf("%d", i+1)
-		} else {
-			query += "$" + fmt.Sprintf("%d", i+1) + ", "
-		}
-	}
-	query += ") order by i.id"
-
-	if query == "select i.price from e_commerce.items i where i.id in () order by i.id" {
-		return 0.0, errors.New("Error there are no items in this person's cart")
-	}
-
-	if len(itemIDs) == 0 {
-		return 0.0, errors.New("There are no items in your cart")
-	}
-
-	rows, err = conn.Query(context.Background(), query, itemIDs...)
-	if err != nil {
-		return 0.0, err
-	}
-
-	var price float32 = 0
-	index := 0
-	for rows.Next() {
-		innerPrice := 0.0
-		err = rows.Scan(&innerPrice)
-		if err != nil {
-			return 0.0, err
-		}
-
-		price = float32(innerPrice) * float32(quantities[index])
-		index++
-	}
-
-	if rows.Err() != nil {
-		return 0.0, rows.Err()
-	}
-
-	return price, nil
+type CartHandler struct {
+	db    database.DB
+	carts *CartRepository
+	items *ItemRepository
+	users *AuthRepository
 }
 
-func CreateCartTable(conn *pgx.Conn) error {
-	_, err := conn.Exec(context.Background(), "create table if not exists e_commerce.cart (id serial primary key, item_id int references e_commerce.items (id)"+
-		", user_id int references e_commerce.authentication(id), quantity int)")
-	return err
+func NewCartHandler(db database.DB) *CartHandler {
+	return &CartHandler{db: db, carts: NewCartRepository(db), items: NewItemRepository(db), users: NewAuthRepository(db)}
 }
 
-func ItemAlreadyInCart(conn *pgx.Conn, itemID, userID int) (bool, error) {
-	check := 0
-	err := conn.QueryRow(context.Background(), "select id from e_commerce.cart where item_id = $1 and user_id = $2", itemID, userID).Scan(&check)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return false, nil
-		}
-
-		return false, err
-	}
-
-	return true, nil
+func (c Coupon) IsValid() bool {
+	return c.ExpirationDate.Unix() >= time.Now().Unix() && c.Discount <= 100
 }
 
-func Pay(email string, ammount int64) (string, error) { //test
+// Pay starts a payment of the given amount and returns its id and the secret
+// the client confirms it with.
+func Pay(email string, ammount int64) (string, string, error) { //test
 	stripe.Key = os.Getenv("STRIPE_KEY")
 
 	params := &stripe.CustomerParams{
@@ -185,7 +80,7 @@ func Pay(email string, ammount int64) (string, error) { //test
 
 	c, err := customer.New(params)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	paymentIntentParams := &stripe.PaymentIntentParams{
@@ -201,32 +96,20 @@ func Pay(email string, ammount int64) (string, error) { //test
 	if err != nil {
 		if stripeErr, ok := err.(*stripe.Error); ok {
 			log.Println(stripeErr)
-			return "", errors.New("Stripe error")
+			return "", "", errors.New("Stripe error")
 		}
 
-		return "", err
+		return "", "", err
 	}
 
-	return pi.ClientSecret, nil
+	return pi.ID, pi.ClientSecret, nil
 }
 
-func AddItemToCart(c *gin.Context) {
+func (h *CartHandler) AddItemToCart(c *gin.Context) {
 	var information map[string]interface{}
-	json.NewDecoder(c.Request.Body).Decode(&information) // token && itemID && quantity
+	json.NewDecoder(c.Request.Body).Decode(&information) // itemID && (variantID || quantity)
 
-	token, ok := information["token"].(string)
-	if !ok {
-		log.Println("Incorrectly provided token of the user")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token of the user"})
-		return
-	}
-
-	id, _, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error invalid token"})
-		return
-	}
+	id := CurrentUserID(c)
 
 	itemIDFl, ok := information["itemID"].(float64)
 	if !ok {
@@ -242,46 +125,47 @@ func AddItemToCart(c *gin.Context) {
 	}
 	quantity := int(quantityFl)
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+	ctx := c.Request.Context()
+	variant, err := h.items.ResolveVariant(ctx, itemID, OptionalVariantID(information))
 	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
-		return
-	}
-	defer conn.Close(context.Background())
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusConflict, gin.H{"error": "Error this item doesn't exist"})
+			return
+		}
+
+		if err == ErrVariantRequired {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-	if err = CreateCartTable(conn); err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to create a table for the cart"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error couldn't get information if this item exists from the database"})
 		return
 	}
 
-	inCart, err := ItemAlreadyInCart(conn, itemID, id)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+	if quantity < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error the quantity has to be at least 1"})
 		return
 	}
 
-	if inCart {
-		c.JSON(http.StatusConflict, gin.H{"error": "Error item is already in cart"})
+	if variant.Available < quantity {
+		c.JSON(http.StatusConflict, gin.H{"error": ErrOutOfStock.Error()})
 		return
 	}
 
-	exists, err := ItemExists(conn, itemID)
+	inCart, err := h.carts.Contains(ctx, id, variant.ID)
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error couldn't get information if this item exists from the database"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
 	}
 
-	if !exists {
-		c.JSON(http.StatusConflict, gin.H{"error": "Error this item doesn't exist"})
+	if inCart {
+		c.JSON(http.StatusConflict, gin.H{"error": "Error item is already in cart"})
 		return
 	}
 
-	_, err = conn.Exec(context.Background(), "insert into e_commerce.cart (item_id, user_id, quantity) values ($1, $2, $3)", itemID, id, quantity)
-	if err != nil {
+	if err = h.carts.Add(ctx, id, variant, quantity); err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information in the database"})
 		return
@@ -290,122 +174,53 @@ func AddItemToCart(c *gin.Context) {
 	c.JSON(http.StatusOK, nil)
 }
 
-func GetItemsFromCart(c *gin.Context) {
-	var information map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&information)
-
-	token, ok := information["token"]
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
-
-	id, _, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error invalid token"})
-		return
-	}
-
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
-		return
-	}
-	defer conn.Close(context.Background())
+func (h *CartHandler) GetItemsFromCart(c *gin.Context) {
+	h.getCart(c, CurrentUserID(c))
+}
 
-	rows, err := conn.Query(context.Background(), "select item_id, quantity from e_commerce.cart where user_id = $1 order by item_id", id)
+func (h *CartHandler) GetCartOfUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
-		return
-	}
-
-	var itemIDs []interface{}
-	var quantities []int
-	for rows.Next() {
-		itemID := 0
-		quantity := 0
-		err = rows.Scan(&itemID, &quantity)
-		if err != nil {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error working with the information from the database"})
-			return
-		}
-
-		itemIDs = append(itemIDs, itemID)
-		quantities = append(quantities, quantity)
-	}
-
-	if rows.Err() != nil {
-		log.Println(rows.Err())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error working with the information from the database"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the user"})
 		return
 	}
 
-	query := "select name, description, price from e_commerce.items where id in ("
+	h.getCart(c, id)
+}
 
-	for i := range itemIDs {
-		if i == len(itemIDs)-1 {
-			query += "$" + fmt.Sprintf("%d", i+1)
-		} else {
-			query += "$" + fmt.Sprintf("%d", i+1) + ", "
+// getCart responds with the cart of the user, recording it in the audit log
+// when it's looked at by someone else.
+func (h *CartHandler) getCart(c *gin.Context, id int) {
+	ctx := c.Request.Context()
+	items := []CartItem{}
+	err := h.carts.inTx(ctx, func(tx *CartRepository) error {
+		var err error
+		if items, err = tx.Items(ctx, id); err != nil || id == CurrentUserID(c) {
+			return err
 		}
-	}
-
-	query += ") order by id"
-
-	if query == "select name, description, price from e_commerce.items where id in () order by id" {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Error there are no items in your cart"})
-		return
-	}
 
-	rows, err = conn.Query(context.Background(), query, itemIDs...)
+		return Record(c, tx.db, "cart.view", "user", id, nil, nil)
+	})
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information about the items"})
 		return
 	}
 
-	var items []CartItem
-	index := 0
-	for rows.Next() {
-		item := CartItem{}
-		err = rows.Scan(&item.Item.Name, &item.Item.Description, &item.Item.Price)
-		if err != nil {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error working with the information about the items"})
-			return
-		}
-
-		item.Item.ID, _ = itemIDs[index].(int)
-		item.Quantity = quantities[index]
-		index++
-		items = append(items, item)
+	if len(items) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there are no items in your cart"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"cart": items})
 }
 
-func RemoveItemFromCart(c *gin.Context) {
+func (h *CartHandler) RemoveItemFromCart(c *gin.Context) {
 	var information map[string]interface{}
-	json.NewDecoder(c.Request.Body).Decode(&information)
+	json.NewDecoder(c.Request.Body).Decode(&information) // itemID && variantID?
 
-	token, ok := information["token"].(string)
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
-
-	id, _, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
-		return
-	}
+	id := CurrentUserID(c)
 
 	itemID, ok := information["itemID"].(float64)
 	if !ok {
@@ -414,16 +229,7 @@ func RemoveItemFromCart(c *gin.Context) {
 		return
 	}
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
-		return
-	}
-	defer conn.Close(context.Background())
-
-	_, err = conn.Exec(context.Background(), "delete from e_commerce.cart where user_id = $1 and item_id = $2", id, itemID)
-	if err != nil {
+	if err := h.carts.Remove(c.Request.Context(), id, int(itemID), OptionalVariantID(information)); err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to remove the item from your cart"})
 		return
@@ -432,259 +238,195 @@ func RemoveItemFromCart(c *gin.Context) {
 	c.JSON(http.StatusOK, nil)
 }
 
-func CountItemsInCart(c *gin.Context) { // to test
-	var information map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&information)
-
-	token, ok := information["token"]
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
-
-	id, _, err := ValidateJWT(token)
+func (h *CartHandler) CountItemsInCart(c *gin.Context) { // to test
+	count, err := h.carts.Count(c.Request.Context(), CurrentUserID(c))
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error couldn't get information from the database"})
 		return
 	}
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+	c.JSON(http.StatusOK, gin.H{"count": count})
+}
+
+// discountedPrice applies the coupon of the user, if they have one, to the
+// price of their cart.
+func (h *CartHandler) discountedPrice(ctx context.Context, id int) (float32, error) {
+	price, err := h.carts.Price(ctx, id)
 	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
-		return
+		return 0.0, err
 	}
-	defer conn.Close(context.Background())
 
-	count := 0
-	err = conn.QueryRow(context.Background(), "select sum(c.quantity) from e_commerce.cart c where c.user_id = $1", id).Scan(&count)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error couldn't get information from the database"})
-		return
+	coupon, err := h.carts.Coupon(ctx, id)
+	if err != nil && err != ErrNoValidCoupon {
+		return 0.0, err
 	}
 
-	c.JSON(http.StatusOK, gin.H{"count": count})
+	return discounted(price, coupon), nil
 }
 
-func Checkout(c *gin.Context) { // test
-	var information map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&information)
-
-	token, ok := information["token"]
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
+// discounted takes the discount of the coupon off the price, if there is a
+// coupon.
+func discounted(price float32, coupon *Coupon) float32 {
+	if coupon == nil {
+		return price
 	}
 
-	id, _, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
-		return
-	}
+	return price - (price * float32(coupon.Discount) / 100)
+}
+
+// Checkout reserves the stock of everything in the cart, in the warehouses
+// it's going to be shipped from, and starts a payment for it. The cart is emptied and the stock taken once the payment succeeds,
+// see PaymentWebhook, and the reservation lapses after ReservationDuration if
+// it never does.
+func (h *CartHandler) Checkout(c *gin.Context) { // test
+	id := CurrentUserID(c)
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+	ctx := c.Request.Context()
+	verified, err := h.users.IsVerified(ctx, id)
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
 	}
-	defer conn.Close(context.Background())
 
-	price, err := getCartPrice(conn, id)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+	if !verified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Error you have to verify your email before paying"})
 		return
 	}
 
-	coupon := Coupon{}
-	if err = coupon.GetCoupon(conn, id); err != nil {
-		if err.Error() == "Error there is no valid coupon for this user" {
-			_, err = conn.Exec(context.Background(), "delete from e_commerce.cart where user_id = $1", id)
-			if err != nil {
-				log.Println(err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to remove the items from the cart after paying"})
-				return
-			}
-
-			purchasePoints := int(price * 10)
-
-			if err = givePurchasePoints(conn, purchasePoints, id); err != nil {
-				log.Println(err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to give purcahase points to the user"})
-				return
-			}
-
-			email, err := GetEmail(token)
-			if err != nil {
-				log.Println(err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get the email of the person from their token"})
-				return
-			}
-
-			secret, err := Pay(email, int64(price)*100)
-			if err != nil {
-				log.Println(err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to pay"})
-				return
-			}
-
-			c.JSON(http.StatusOK, gin.H{"secret": secret})
+	allocations, price, err := h.carts.Reserve(ctx, id, time.Now().Add(ReservationDuration))
+	if err != nil {
+		if err == ErrOutOfStock {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	discountedPrice := price - (price * float32(coupon.Discount) / 100)
+		if err == ErrEmptyCart {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there are no items in your cart"})
+			return
+		}
 
-	_, err = conn.Exec(context.Background(), "delete from e_commerce.cart where user_id = $1", id)
-	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to remove the items from the cart after paying"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to reserve the items in your cart"})
 		return
 	}
 
-	purchasePoints := int(discountedPrice * 10)
+	email := CurrentEmail(c)
 
-	if err = givePurchasePoints(conn, purchasePoints, id); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to give purcahase points to the user"})
-		return
+	paymentIntent, secret, err := Pay(email, int64(price*100))
+	if err == nil {
+		err = h.carts.AttachPayment(ctx, id, paymentIntent)
 	}
 
-	email, err := GetEmail(token)
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get the email of teh person from their token"})
-		return
-	}
+		if err := h.carts.ReleaseReservations(ctx, id, ""); err != nil {
+			log.Println(err)
+		}
 
-	secret, err := Pay(email, int64(discountedPrice)*100)
-	if err != nil {
-		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to pay"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"secret": secret})
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "allocations": allocations})
 }
 
-func RemoveEverythingFromCart(c *gin.Context) {
-	var information map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&information)
-
-	token, ok := information["token"]
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
+// PaymentWebhook receives the events of the payments from Stripe. A
+// successful payment takes the reserved stock, empties the cart and gives the
+// user their purchase points, while a canceled one releases the stock. A
+// failed payment can still be retried, so its stock stays reserved until the
+// reservation expires.
+func (h *CartHandler) PaymentWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookSize))
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error unable to read the event"})
 		return
 	}
 
-	id, _, err := ValidateJWT(token)
+	event, err := webhook.ConstructEventWithOptions(payload, c.GetHeader("Stripe-Signature"), os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		webhook.ConstructEventOptions{IgnoreAPIVersionMismatch: true})
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error invalid signature of the event"})
 		return
 	}
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
+	var intent stripe.PaymentIntent
+	if err = json.Unmarshal(event.Data.Raw, &intent); err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error unable to read the payment of the event"})
 		return
 	}
-	defer conn.Close(context.Background())
 
-	check := 0
-	err = conn.QueryRow(context.Background(), "delete from e_commerce.cart where user_id = $1 returning id", id).Scan(&check)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Error there are no items in your cart"})
+	ctx := c.Request.Context()
+	switch event.Type {
+	case stripe.EventTypePaymentIntentSucceeded:
+		id, unfulfilled, err := h.carts.CommitReservations(ctx, intent.ID)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
 			return
 		}
 
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to remove the items from your cart"})
-		return
+		if len(unfulfilled) > 0 {
+			log.Printf("Payment %s can't be fulfilled, there isn't enough of the variants %v in stock any more, it has to be refunded", intent.ID, unfulfilled)
+			break
+		}
+
+		if id == 0 {
+			break
+		}
+
+		if err = h.users.AddPoints(ctx, id, int(intent.Amount/10), "purchase"); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to give purcahase points to the user"})
+			return
+		}
+	case stripe.EventTypePaymentIntentCanceled:
+		if err = h.carts.ReleaseReservations(ctx, 0, intent.ID); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+			return
+		}
 	}
 
 	c.JSON(http.StatusOK, nil)
 }
 
-func GetCartPrice(c *gin.Context) {
-	var information map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&information)
-
-	token, ok := information["token"]
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error Incorrectly provided token"})
-		return
-	}
-
-	id, _, err := ValidateJWT(token)
+func (h *CartHandler) RemoveEverythingFromCart(c *gin.Context) {
+	removed, err := h.carts.Clear(c.Request.Context(), CurrentUserID(c))
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to remove the items from your cart"})
 		return
 	}
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
+	if removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there are no items in your cart"})
 		return
 	}
-	defer conn.Close(context.Background())
 
-	price, err := getCartPrice(conn, id)
+	c.JSON(http.StatusOK, nil)
+}
+
+func (h *CartHandler) GetCartPrice(c *gin.Context) {
+	price, err := h.discountedPrice(c.Request.Context(), CurrentUserID(c))
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	log.Println(price)
-
-	coupon := Coupon{}
-	if err = coupon.GetCoupon(conn, id); err != nil {
-		if err.Error() == "Error there is no valid coupon for this user" {
-			c.JSON(http.StatusOK, gin.H{"price": price})
-			return
-		}
 
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	discountedPrice := price - (price * float32(coupon.Discount) / 100)
-
-	c.JSON(http.StatusOK, gin.H{"price": discountedPrice})
+	c.JSON(http.StatusOK, gin.H{"price": price})
 }
 
-func ApplyCoupon(c *gin.Context) {
+func (h *CartHandler) ApplyCoupon(c *gin.Context) {
 	var information map[string]interface{}
-	json.NewDecoder(c.Request.Body).Decode(&information) //token && expDate && couponNumber && discount
+	json.NewDecoder(c.Request.Body).Decode(&information) // expDate && couponNumber && discount
 
-	token, ok := information["token"].(string)
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
-
-	id, _, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
-		return
-	}
+	id := CurrentUserID(c)
 
 	coupon := Coupon{}
 
@@ -695,12 +437,13 @@ func ApplyCoupon(c *gin.Context) {
 		return
 	}
 
-	coupon.ExpirationDate, err = time.Parse(time.DateOnly, expDate)
+	expirationDate, err := time.Parse(time.DateOnly, expDate)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to parse the given date"})
 		return
 	}
+	coupon.ExpirationDate = expirationDate
 
 	number, ok := information["couponNumber"].(float64)
 	if !ok {
@@ -718,28 +461,27 @@ func ApplyCoupon(c *gin.Context) {
 	}
 	coupon.Discount = uint8(discount)
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+	ctx := c.Request.Context()
+	taken, err := h.carts.CouponNumberTaken(ctx, coupon.CouponNumber)
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
-		return
-	}
-	defer conn.Close(context.Background())
-
-	if err = CreateCouponsTable(conn); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to create a table for the coupons"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
 	}
 
-	if !coupon.IsValid(conn) {
+	if taken || !coupon.IsValid() {
 		log.Println("Invalid coupon")
 		c.JSON(http.StatusNotAcceptable, gin.H{"error": "Error invalid coupon"})
 		return
 	}
 
-	_, err = conn.Exec(context.Background(), "insert into e_commerce.coupons (exp_date, discount, number, user_id, used) values ($1, $2, $3, $4, false)",
-		coupon.ExpirationDate, coupon.Discount, coupon.CouponNumber, id)
+	err = h.carts.inTx(ctx, func(tx *CartRepository) error {
+		if err := tx.AddCoupon(ctx, id, &coupon); err != nil {
+			return err
+		}
+
+		return Record(c, tx.db, "coupon.apply", "coupon", coupon.ID, nil, coupon)
+	})
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information in the database"})
@@ -749,23 +491,11 @@ func ApplyCoupon(c *gin.Context) {
 	c.JSON(http.StatusOK, nil)
 }
 
-func RemoveCoupon(c *gin.Context) {
+func (h *CartHandler) RemoveCoupon(c *gin.Context) {
 	var information map[string]interface{}
-	json.NewDecoder(c.Request.Body).Decode(&information) // token && couponNumber
-
-	token, ok := information["token"].(string)
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
+	json.NewDecoder(c.Request.Body).Decode(&information) // couponNumber
 
-	id, _, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
-		return
-	}
+	id := CurrentUserID(c)
 
 	couponNumberFL, ok := information["couponNumber"].(float64)
 	if !ok {
@@ -775,16 +505,15 @@ func RemoveCoupon(c *gin.Context) {
 	}
 	couponNumber := uint(couponNumberFL)
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
-		return
-	}
-	defer conn.Close(context.Background())
+	ctx := c.Request.Context()
+	err := h.carts.inTx(ctx, func(tx *CartRepository) error {
+		coupon, err := tx.RemoveCoupon(ctx, id, couponNumber)
+		if err != nil {
+			return err
+		}
 
-	check := 0
-	err = conn.QueryRow(context.Background(), "delete from e_commerce.coupons where user_id = $1 and number = $2 returning id", id, couponNumber).Scan(&check)
+		return Record(c, tx.db, "coupon.remove", "coupon", coupon.ID, coupon, nil)
+	})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no coupon with this number for this user"})
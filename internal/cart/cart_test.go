@@ -0,0 +1,24 @@
+package cart
+
+import "testing"
+
+func TestDiscounted(t *testing.T) {
+	tests := []struct {
+		name   string
+		coupon *Coupon
+		price  float32
+	}{
+		{"no coupon", nil, 80},
+		{"no discount", &Coupon{Discount: 0}, 80},
+		{"quarter off", &Coupon{Discount: 25}, 60},
+		{"free", &Coupon{Discount: 100}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if price := discounted(80, test.coupon); price != test.price {
+				t.Errorf("discounted(80) = %v, want %v", price, test.price)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package cart
+
+import (
+	"context"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	. "github.com/Phantomvv1/E-commerce/internal/items"
+	"github.com/jackc/pgx/v5"
+)
+
+type CartRepository struct {
+	db database.DB
+}
+
+func NewCartRepository(db database.DB) *CartRepository {
+	return &CartRepository{db: db}
+}
+
+// inTx runs fn with a repository bound to a transaction.
+func (r *CartRepository) inTx(ctx context.Context, fn func(tx *CartRepository) error) error {
+	return database.InTx(ctx, r.db, func(tx pgx.Tx) error {
+		return fn(&CartRepository{db: tx})
+	})
+}
+
+func (r *CartRepository) Contains(ctx context.Context, userID, variantID int) (bool, error) {
+	exists := false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from e_commerce.cart where variant_id = $1 and user_id = $2)", variantID, userID).Scan(&exists)
+	return exists, err
+}
+
+func (r *CartRepository) Add(ctx context.Context, userID int, variant *Variant, quantity int) error {
+	_, err := r.db.Exec(ctx, "insert into e_commerce.cart (item_id, variant_id, user_id, quantity) values ($1, $2, $3, $4)", variant.ItemID, variant.ID, userID, quantity)
+	return err
+}
+
+func (r *CartRepository) Items(ctx context.Context, userID int) ([]CartItem, error) {
+	rows, err := r.db.Query(ctx, "select i.id, i.name, i.description, i.price, "+VariantColumns+", c.quantity from e_commerce.cart c "+
+		"join e_commerce.variants v on v.id = c.variant_id join e_commerce.items i on i.id = v.item_id where c.user_id = $1 order by i.id, v.id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []CartItem{}
+	for rows.Next() {
+		item := CartItem{}
+		err = rows.Scan(&item.Item.ID, &item.Item.Name, &item.Item.Description, &item.Item.Price,
+			&item.Variant.ID, &item.Variant.ItemID, &item.Variant.SKU, &item.Variant.Options, &item.Variant.Price, &item.Variant.Stock, &item.Variant.Available, &item.Quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// Remove takes the variant out of the cart, or every variant of the item
+// when variantID is nil.
+func (r *CartRepository) Remove(ctx context.Context, userID, itemID int, variantID *int) error {
+	_, err := r.db.Exec(ctx, "delete from e_commerce.cart where user_id = $1 and item_id = $2 and ($3::int is null or variant_id = $3)", userID, itemID, variantID)
+	return err
+}
+
+func (r *CartRepository) Count(ctx context.Context, userID int) (int, error) {
+	count := 0
+	err := r.db.QueryRow(ctx, "select coalesce(sum(c.quantity), 0) from e_commerce.cart c where c.user_id = $1", userID).Scan(&count)
+	return count, err
+}
+
+// Clear empties the cart of the user and returns how many items were in it.
+func (r *CartRepository) Clear(ctx context.Context, userID int) (int64, error) {
+	result, err := r.db.Exec(ctx, "delete from e_commerce.cart where user_id = $1", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// Price returns ErrEmptyCart when there is nothing in the cart of the user.
+func (r *CartRepository) Price(ctx context.Context, userID int) (float32, error) {
+	var price *float64
+	err := r.db.QueryRow(ctx, "select sum(coalesce(v.price, i.price) * c.quantity) from e_commerce.cart c join e_commerce.variants v on v.id = c.variant_id "+
+		"join e_commerce.items i on i.id = v.item_id where c.user_id = $1", userID).Scan(&price)
+	if err != nil {
+		return 0.0, err
+	}
+
+	if price == nil {
+		return 0.0, ErrEmptyCart
+	}
+
+	return float32(*price), nil
+}
+
+func (r *CartRepository) CouponNumberTaken(ctx context.Context, number uint) (bool, error) {
+	exists := false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from e_commerce.coupons c where c.number = $1)", number).Scan(&exists)
+	return exists, err
+}
+
+// Coupon returns ErrNoValidCoupon when the user has no unused coupon which
+// hasn't expired.
+func (r *CartRepository) Coupon(ctx context.Context, userID int) (*Coupon, error) {
+	coupon := &Coupon{}
+	err := r.db.QueryRow(ctx, "select id, exp_date, discount, number from e_commerce.coupons c where c.used = false and c.exp_date > current_date and user_id = $1", userID).
+		Scan(&coupon.ID, &coupon.ExpirationDate, &coupon.Discount, &coupon.CouponNumber)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrNoValidCoupon
+		}
+
+		return nil, err
+	}
+
+	return coupon, nil
+}
+
+func (r *CartRepository) AddCoupon(ctx context.Context, userID int, coupon *Coupon) error {
+	return r.db.QueryRow(ctx, "insert into e_commerce.coupons (exp_date, discount, number, user_id, used) values ($1, $2, $3, $4, false) returning id",
+		coupon.ExpirationDate, coupon.Discount, coupon.CouponNumber, userID).Scan(&coupon.ID)
+}
+
+// RemoveCoupon returns the coupon as it was before it was removed.
+func (r *CartRepository) RemoveCoupon(ctx context.Context, userID int, number uint) (*Coupon, error) {
+	coupon := &Coupon{}
+	err := r.db.QueryRow(ctx, "delete from e_commerce.coupons where user_id = $1 and number = $2 returning id, exp_date, discount, number", userID, number).
+		Scan(&coupon.ID, &coupon.ExpirationDate, &coupon.Discount, &coupon.CouponNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return coupon, nil
+}
+
+// Reserve holds the stock of everything in the cart of the user until
+// expiresAt, in the warehouses Allocate picks for it, and returns the price of
+// what it reserved with the coupon of the user applied. The earlier
+// reservations of the user, which a new checkout replaces, and every expired
+// one are released first. It returns ErrOutOfStock when there isn't enough of
+// something in the cart.
+func (r *CartRepository) Reserve(ctx context.Context, userID int, expiresAt time.Time) ([]Allocation, float32, error) {
+	allocations := []Allocation{}
+	price := 0.0
+	err := database.InTx(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "update e_commerce.stock_reservations set status = 'released' where status = 'active' and (user_id = $1 or expires_at <= now())", userID)
+		if err != nil {
+			return err
+		}
+
+		// Every checkout locks the variants of its cart before it reads what's
+		// reserved, in a statement of its own. A statement only sees what was
+		// committed when it started, so reading the reservations in the
+		// statement that waits for the locks would miss the ones the checkout
+		// holding them was making, and both could reserve the last one. The
+		// items are locked too, so the price can't change before the
+		// reservation is made.
+		rows, err := tx.Query(ctx, "select c.variant_id, c.quantity, coalesce(v.price, i.price) from e_commerce.cart c "+
+			"join e_commerce.variants v on v.id = c.variant_id join e_commerce.items i on i.id = v.item_id "+
+			"where c.user_id = $1 order by i.id, v.id for update of v, i", userID)
+		if err != nil {
+			return err
+		}
+
+		requests := []StockRequest{}
+		for rows.Next() {
+			request := StockRequest{}
+			itemPrice := 0.0
+			if err = rows.Scan(&request.VariantID, &request.Quantity, &itemPrice); err != nil {
+				rows.Close()
+				return err
+			}
+
+			requests = append(requests, request)
+			price += itemPrice * float64(request.Quantity)
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if len(requests) == 0 {
+			return ErrEmptyCart
+		}
+
+		if allocations, err = NewItemRepository(tx).Allocate(ctx, requests); err != nil {
+			return err
+		}
+
+		for _, allocation := range allocations {
+			_, err = tx.Exec(ctx, "insert into e_commerce.stock_reservations (user_id, variant_id, warehouse_id, quantity, expires_at) values ($1, $2, $3, $4, $5)",
+				userID, allocation.VariantID, allocation.WarehouseID, allocation.Quantity, expiresAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		coupon, err := NewCartRepository(tx).Coupon(ctx, userID)
+		if err != nil && err != ErrNoValidCoupon {
+			return err
+		}
+
+		price = float64(discounted(float32(price), coupon))
+		return nil
+	})
+	if err != nil {
+		return nil, 0.0, err
+	}
+
+	return allocations, float32(price), nil
+}
+
+// AttachPayment ties the active reservations of the user to the payment
+// which pays for them.
+func (r *CartRepository) AttachPayment(ctx context.Context, userID int, paymentIntent string) error {
+	_, err := r.db.Exec(ctx, "update e_commerce.stock_reservations set payment_intent = $2 where user_id = $1 and status = 'active'", userID, paymentIntent)
+	return err
+}
+
+// ReleaseReservations gives back the stock held for the user, or for the
+// payment when userID is 0.
+func (r *CartRepository) ReleaseReservations(ctx context.Context, userID int, paymentIntent string) error {
+	_, err := r.db.Exec(ctx, "update e_commerce.stock_reservations set status = 'released' where status = 'active' and "+
+		"(($1 <> 0 and user_id = $1) or ($1 = 0 and payment_intent = $2))", userID, paymentIntent)
+	return err
+}
+
+// CommitReservations takes the stock reserved for a successful payment out of
+// the warehouses it was reserved in, and its variants out of the cart of the
+// user. A reservation which has expired or been released in the meantime is
+// still committed, as it has been paid for, but only if its warehouse has
+// enough stock which nobody else has reserved. Otherwise it's marked as
+// unfulfilled, and its variant returned, so the payment can be refunded. It
+// returns the id of the user, or 0 when the payment has already been
+// committed.
+func (r *CartRepository) CommitReservations(ctx context.Context, paymentIntent string) (int, []int, error) {
+	userID := 0
+	unfulfilled := []int{}
+	err := database.InTx(ctx, r.db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, "select id, user_id, variant_id, warehouse_id, quantity, status = 'active' and expires_at > now() "+
+			"from e_commerce.stock_reservations where payment_intent = $1 and status in ('active', 'released') order by variant_id, id for update", paymentIntent)
+		if err != nil {
+			return err
+		}
+
+		type reservation struct {
+			id, variantID, warehouseID, quantity int
+			held                                 bool
+		}
+		reservations := []reservation{}
+		for rows.Next() {
+			reserved := reservation{}
+			if err = rows.Scan(&reserved.id, &userID, &reserved.variantID, &reserved.warehouseID, &reserved.quantity, &reserved.held); err != nil {
+				rows.Close()
+				return err
+			}
+
+			reservations = append(reservations, reserved)
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		items := NewItemRepository(tx)
+		committed := []int{}
+		for _, reserved := range reservations {
+			fulfilled, err := takeReserved(ctx, items, reserved.id, reserved.variantID, reserved.warehouseID, reserved.quantity, reserved.held)
+			if err != nil {
+				return err
+			}
+
+			status := "committed"
+			if fulfilled {
+				committed = append(committed, reserved.variantID)
+			} else {
+				status = "unfulfilled"
+				unfulfilled = append(unfulfilled, reserved.variantID)
+			}
+
+			_, err = tx.Exec(ctx, "update e_commerce.stock_reservations set status = $2 where id = $1", reserved.id, status)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx, "delete from e_commerce.cart where user_id = $1 and variant_id = any($2)", userID, committed)
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return userID, unfulfilled, nil
+}
+
+// takeReserved takes a reservation out of the stock of its warehouse and
+// reports whether there was enough of it. A reservation which isn't held any
+// more can only take the stock nobody else has reserved.
+func takeReserved(ctx context.Context, items *ItemRepository, id, variantID, warehouseID, quantity int, held bool) (bool, error) {
+	if !held {
+		levels, err := items.LockedStockLevels(ctx, variantID)
+		if err != nil {
+			return false, err
+		}
+
+		if !availableIn(levels, warehouseID, quantity) {
+			return false, nil
+		}
+	}
+
+	sale := StockMovement{VariantID: variantID, WarehouseID: warehouseID, Change: -quantity, Reason: StockSale, ReservationID: &id}
+	err := items.AdjustStock(ctx, &sale)
+	if err == ErrOutOfStock || err == pgx.ErrNoRows {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// availableIn reports whether the warehouse has the quantity of stock which
+// isn't reserved in levels.
+func availableIn(levels []WarehouseStock, warehouseID, quantity int) bool {
+	for _, level := range levels {
+		if level.WarehouseID == warehouseID {
+			return level.Available >= quantity
+		}
+	}
+
+	return false
+}
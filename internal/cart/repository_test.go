@@ -0,0 +1,35 @@
+package cart
+
+import (
+	"testing"
+
+	. "github.com/Phantomvv1/E-commerce/internal/items"
+)
+
+func TestAvailableIn(t *testing.T) {
+	levels := []WarehouseStock{
+		{WarehouseID: 1, VariantID: 7, Stock: 10, Available: 4},
+		{WarehouseID: 2, VariantID: 7, Stock: 3, Available: 0},
+	}
+
+	tests := []struct {
+		name        string
+		warehouseID int
+		quantity    int
+		available   bool
+	}{
+		{"enough", 1, 3, true},
+		{"all of it", 1, 4, true},
+		{"reserved by someone else", 1, 5, false},
+		{"everything reserved", 2, 1, false},
+		{"not in the warehouse", 3, 1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if available := availableIn(levels, test.warehouseID, test.quantity); available != test.available {
+				t.Errorf("availableIn(%d, %d) = %v, want %v", test.warehouseID, test.quantity, available, test.available)
+			}
+		})
+	}
+}
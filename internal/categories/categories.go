@@ -0,0 +1,374 @@
+package categories
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	. "github.com/Phantomvv1/E-commerce/internal/items"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	validSlug    = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	notSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+type Category struct {
+	ID       int        `json:"id"`
+	ParentID *int       `json:"parentID"`
+	Name     string     `json:"name"`
+	Slug     string     `json:"slug"`
+	Position int        `json:"position"`
+	Children []Category `json:"children,omitempty"`
+}
+
+type CategoryHandler struct {
+	db         database.DB
+	categories *CategoryRepository
+	items      *ItemRepository
+}
+
+func NewCategoryHandler(db database.DB) *CategoryHandler {
+	return &CategoryHandler{db: db, categories: NewCategoryRepository(db), items: NewItemRepository(db)}
+}
+
+// slugify makes a slug out of the name of a category, like "Men's shoes" to
+// "men-s-shoes".
+func slugify(name string) string {
+	return strings.Trim(notSlugChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// buildTree nests the categories under their parents, keeping the order they
+// came in.
+func buildTree(categories []Category) []Category {
+	children := map[int][]Category{}
+	roots := []Category{}
+	for _, category := range categories {
+		if category.ParentID == nil {
+			roots = append(roots, category)
+			continue
+		}
+
+		children[*category.ParentID] = append(children[*category.ParentID], category)
+	}
+
+	var attach func(categories []Category) []Category
+	attach = func(categories []Category) []Category {
+		for i := range categories {
+			categories[i].Children = attach(children[categories[i].ID])
+		}
+
+		return categories
+	}
+
+	return attach(roots)
+}
+
+func categoryStatus(err error) int {
+	switch err {
+	case errSlugTaken, errHasChildren:
+		return http.StatusConflict
+	case errParentNotFound, errNoSuchCategory:
+		return http.StatusNotFound
+	case errCategoryCycle:
+		return http.StatusBadRequest
+	}
+
+	return 0
+}
+
+// GetCategories returns the whole category tree.
+func (h *CategoryHandler) GetCategories(c *gin.Context) {
+	categories, err := h.categories.All(c.Request.Context())
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": buildTree(categories)})
+}
+
+// GetCategory returns the category together with its subcategories and the
+// categories above it, for breadcrumbs.
+func (h *CategoryHandler) GetCategory(c *gin.Context) {
+	ctx := c.Request.Context()
+	category, err := h.categories.BySlug(ctx, c.Param("slug"))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no category with this slug"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	children, err := h.categories.Children(ctx, category.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+	category.Children = children
+
+	path, err := h.categories.Ancestors(ctx, category.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"category": category, "path": path})
+}
+
+func (h *CategoryHandler) CreateCategory(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // name && (slug || parentID || position)
+
+	name, ok := information["name"].(string)
+	if !ok || strings.TrimSpace(name) == "" {
+		log.Println("Incorrectly provided name of the category")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided name of the category"})
+		return
+	}
+
+	category := Category{Name: strings.TrimSpace(name), Slug: slugify(name)}
+	if slug, ok := information["slug"].(string); ok {
+		category.Slug = slug
+	}
+
+	if !validSlug.MatchString(category.Slug) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error the slug can contain only lowercase letters, digits and single dashes between them"})
+		return
+	}
+
+	if parentID, ok := information["parentID"].(float64); ok {
+		id := int(parentID)
+		category.ParentID = &id
+	}
+
+	if position, ok := information["position"].(float64); ok {
+		category.Position = int(position)
+	}
+
+	ctx := c.Request.Context()
+	err := h.categories.inTx(ctx, func(tx *CategoryRepository) error {
+		if err := tx.Create(ctx, &category); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "category.create", "category", category.ID, nil, category)
+	})
+	if err != nil {
+		if status := categoryStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information about the category in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"category": category})
+}
+
+// UpdateCategory renames, reorders or moves a category. A parentID of null
+// moves it to the top of the tree.
+func (h *CategoryHandler) UpdateCategory(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id && (name || slug || parentID || position)
+
+	id, ok := information["id"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the category")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the category"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	before, err := h.categories.Get(ctx, int(id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no category with this id"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	after := *before
+	if name, ok := information["name"].(string); ok && strings.TrimSpace(name) != "" {
+		after.Name = strings.TrimSpace(name)
+	}
+
+	if slug, ok := information["slug"].(string); ok {
+		if !validSlug.MatchString(slug) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error the slug can contain only lowercase letters, digits and single dashes between them"})
+			return
+		}
+
+		after.Slug = slug
+	}
+
+	if parent, ok := information["parentID"]; ok {
+		switch parentID := parent.(type) {
+		case nil:
+			after.ParentID = nil
+		case float64:
+			id := int(parentID)
+			after.ParentID = &id
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the parent category"})
+			return
+		}
+	}
+
+	if position, ok := information["position"].(float64); ok {
+		after.Position = int(position)
+	}
+
+	err = h.categories.inTx(ctx, func(tx *CategoryRepository) error {
+		if err := tx.Update(ctx, &after); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "category.update", "category", after.ID, before, after)
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no category with this id"})
+			return
+		}
+
+		if status := categoryStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"category": after})
+}
+
+func (h *CategoryHandler) DeleteCategory(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id
+
+	id, ok := information["id"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the category")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the category"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := h.categories.inTx(ctx, func(tx *CategoryRepository) error {
+		category, err := tx.Delete(ctx, int(id))
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "category.delete", "category", category.ID, category, nil)
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no category with this id"})
+			return
+		}
+
+		if status := categoryStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to delete the category from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+// SetItemCategories replaces the categories an item is in.
+func (h *CategoryHandler) SetItemCategories(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // itemID && categoryIDs
+
+	itemIDFl, ok := information["itemID"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the item")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the item"})
+		return
+	}
+	itemID := int(itemIDFl)
+
+	ids, ok := information["categoryIDs"].([]interface{})
+	if !ok {
+		log.Println("Incorrectly provided ids of the categories")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided ids of the categories"})
+		return
+	}
+
+	categoryIDs := []int{}
+	for _, id := range ids {
+		categoryID, ok := id.(float64)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided ids of the categories"})
+			return
+		}
+
+		categoryIDs = append(categoryIDs, int(categoryID))
+	}
+
+	ctx := c.Request.Context()
+	exists, err := h.items.Exists(ctx, itemID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no item with this id"})
+		return
+	}
+
+	err = h.categories.inTx(ctx, func(tx *CategoryRepository) error {
+		before, err := tx.ItemCategories(ctx, itemID)
+		if err != nil {
+			return err
+		}
+
+		if err = tx.SetItemCategories(ctx, itemID, categoryIDs); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "item.categories", "item", itemID, gin.H{"categoryIDs": before}, gin.H{"categoryIDs": categoryIDs})
+	})
+	if err != nil {
+		if status := categoryStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
@@ -0,0 +1,46 @@
+package categories
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildTree(t *testing.T) {
+	parent := func(id int) *int { return &id }
+	categories := []Category{
+		{ID: 1, Name: "Clothes"},
+		{ID: 4, Name: "Shirts", ParentID: parent(1)},
+		{ID: 2, Name: "Books"},
+		{ID: 3, Name: "Shoes", ParentID: parent(1)},
+		{ID: 5, Name: "Sneakers", ParentID: parent(3)},
+		{ID: 6, Name: "Orphan", ParentID: parent(9)},
+	}
+
+	want := []Category{
+		{ID: 1, Name: "Clothes", Children: []Category{
+			{ID: 4, Name: "Shirts", ParentID: parent(1)},
+			{ID: 3, Name: "Shoes", ParentID: parent(1), Children: []Category{
+				{ID: 5, Name: "Sneakers", ParentID: parent(3)},
+			}},
+		}},
+		{ID: 2, Name: "Books"},
+	}
+
+	if tree := buildTree(categories); !reflect.DeepEqual(tree, want) {
+		t.Errorf("buildTree() = %+v, want %+v", tree, want)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Shoes":              "shoes",
+		"Men's Shoes":        "men-s-shoes",
+		"  T-Shirts & Tops ": "t-shirts-tops",
+	}
+
+	for name, slug := range tests {
+		if got := slugify(name); got != slug {
+			t.Errorf("slugify(%q) = %q, want %q", name, got, slug)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package categories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	errSlugTaken      = errors.New("Error there is already a category with this slug")
+	errParentNotFound = errors.New("Error there is no parent category with this id")
+	errCategoryCycle  = errors.New("Error a category can't be moved under itself or one of its subcategories")
+	errHasChildren    = errors.New("Error the category has subcategories, move or delete them first")
+	errNoSuchCategory = errors.New("Error there is no category with one of these ids")
+)
+
+type CategoryRepository struct {
+	db database.DB
+}
+
+func NewCategoryRepository(db database.DB) *CategoryRepository {
+	return &CategoryRepository{db: db}
+}
+
+func (r *CategoryRepository) inTx(ctx context.Context, fn func(tx *CategoryRepository) error) error {
+	return database.InTx(ctx, r.db, func(tx pgx.Tx) error {
+		return fn(&CategoryRepository{db: tx})
+	})
+}
+
+// categoryError turns the violated constraints into the errors the handlers
+// know how to answer. A missing category which is referenced is reported as
+// missingReference.
+func categoryError(err, missingReference error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case "23505":
+		return errSlugTaken
+	case "23503":
+		return missingReference
+	}
+
+	return err
+}
+
+func scanCategories(rows pgx.Rows) ([]Category, error) {
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		category := Category{}
+		if err := rows.Scan(&category.ID, &category.ParentID, &category.Name, &category.Slug, &category.Position); err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, category)
+	}
+
+	return categories, rows.Err()
+}
+
+// All returns every category with the siblings ordered by their position.
+func (r *CategoryRepository) All(ctx context.Context) ([]Category, error) {
+	rows, err := r.db.Query(ctx, "select id, parent_id, name, slug, position from e_commerce.categories order by position, name")
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCategories(rows)
+}
+
+func (r *CategoryRepository) Get(ctx context.Context, id int) (*Category, error) {
+	category := &Category{}
+	err := r.db.QueryRow(ctx, "select id, parent_id, name, slug, position from e_commerce.categories where id = $1", id).
+		Scan(&category.ID, &category.ParentID, &category.Name, &category.Slug, &category.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	return category, nil
+}
+
+func (r *CategoryRepository) BySlug(ctx context.Context, slug string) (*Category, error) {
+	category := &Category{}
+	err := r.db.QueryRow(ctx, "select id, parent_id, name, slug, position from e_commerce.categories where slug = $1", slug).
+		Scan(&category.ID, &category.ParentID, &category.Name, &category.Slug, &category.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	return category, nil
+}
+
+func (r *CategoryRepository) Children(ctx context.Context, id int) ([]Category, error) {
+	rows, err := r.db.Query(ctx, "select id, parent_id, name, slug, position from e_commerce.categories where parent_id = $1 order by position, name", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCategories(rows)
+}
+
+// Ancestors returns the categories above this one, starting from the root.
+func (r *CategoryRepository) Ancestors(ctx context.Context, id int) ([]Category, error) {
+	rows, err := r.db.Query(ctx, "with recursive path as (select c.*, 0 as depth from e_commerce.categories c where c.id = (select parent_id from e_commerce.categories where id = $1) "+
+		"union all select c.*, p.depth + 1 from e_commerce.categories c join path p on c.id = p.parent_id) "+
+		"select id, parent_id, name, slug, position from path order by depth desc", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanCategories(rows)
+}
+
+func (r *CategoryRepository) Create(ctx context.Context, category *Category) error {
+	err := r.db.QueryRow(ctx, "insert into e_commerce.categories (parent_id, name, slug, position) values ($1, $2, $3, $4) returning id",
+		category.ParentID, category.Name, category.Slug, category.Position).Scan(&category.ID)
+	return categoryError(err, errParentNotFound)
+}
+
+// isDescendant reports whether candidate is the category itself or one of the
+// categories under it, going up from candidate to the root.
+func isDescendant(categories []Category, id, candidate int) bool {
+	parents := map[int]*int{}
+	for _, category := range categories {
+		parents[category.ID] = category.ParentID
+	}
+
+	visited := map[int]bool{}
+	for current := &candidate; current != nil && !visited[*current]; current = parents[*current] {
+		if *current == id {
+			return true
+		}
+
+		visited[*current] = true
+	}
+
+	return false
+}
+
+func (r *CategoryRepository) Update(ctx context.Context, category *Category) error {
+	return r.inTx(ctx, func(tx *CategoryRepository) error {
+		if category.ParentID != nil {
+			// Two moves which are fine on their own can make a cycle together,
+			// which the recursive queries would never get out of, so they're
+			// checked one at a time. The categories can still be read meanwhile.
+			if _, err := tx.db.Exec(ctx, "lock table e_commerce.categories in share row exclusive mode"); err != nil {
+				return err
+			}
+
+			categories, err := tx.All(ctx)
+			if err != nil {
+				return err
+			}
+
+			if isDescendant(categories, category.ID, *category.ParentID) {
+				return errCategoryCycle
+			}
+		}
+
+		result, err := tx.db.Exec(ctx, "update e_commerce.categories set parent_id = $1, name = $2, slug = $3, position = $4 where id = $5",
+			category.ParentID, category.Name, category.Slug, category.Position, category.ID)
+		if err != nil {
+			return categoryError(err, errParentNotFound)
+		}
+
+		if result.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+
+		return nil
+	})
+}
+
+// Delete removes a category which has no subcategories. Its items stay in the
+// shop, they just aren't in the category anymore.
+func (r *CategoryRepository) Delete(ctx context.Context, id int) (*Category, error) {
+	category := &Category{}
+	err := r.db.QueryRow(ctx, "delete from e_commerce.categories where id = $1 returning id, parent_id, name, slug, position", id).
+		Scan(&category.ID, &category.ParentID, &category.Name, &category.Slug, &category.Position)
+	if err != nil {
+		return nil, categoryError(err, errHasChildren)
+	}
+
+	return category, nil
+}
+
+func (r *CategoryRepository) ItemCategories(ctx context.Context, itemID int) ([]int, error) {
+	rows, err := r.db.Query(ctx, "select category_id from e_commerce.item_categories where item_id = $1 order by category_id", itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categoryIDs := []int{}
+	for rows.Next() {
+		categoryID := 0
+		if err = rows.Scan(&categoryID); err != nil {
+			return nil, err
+		}
+
+		categoryIDs = append(categoryIDs, categoryID)
+	}
+
+	return categoryIDs, rows.Err()
+}
+
+// SetItemCategories replaces the categories of the item.
+func (r *CategoryRepository) SetItemCategories(ctx context.Context, itemID int, categoryIDs []int) error {
+	return database.InTx(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "delete from e_commerce.item_categories where item_id = $1", itemID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "insert into e_commerce.item_categories (item_id, category_id) select $1, unnest($2::int[]) on conflict do nothing", itemID, categoryIDs)
+		return categoryError(err, errNoSuchCategory)
+	})
+}
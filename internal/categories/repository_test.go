@@ -0,0 +1,41 @@
+package categories
+
+import "testing"
+
+func TestIsDescendant(t *testing.T) {
+	parent := func(id int) *int { return &id }
+	// 1 > 2 > 3 > 4 and 5 on their own, 6 and 7 are already each other's
+	// parent.
+	categories := []Category{
+		{ID: 1},
+		{ID: 2, ParentID: parent(1)},
+		{ID: 3, ParentID: parent(2)},
+		{ID: 4, ParentID: parent(3)},
+		{ID: 5},
+		{ID: 6, ParentID: parent(7)},
+		{ID: 7, ParentID: parent(6)},
+	}
+
+	tests := []struct {
+		name       string
+		id         int
+		candidate  int
+		descendant bool
+	}{
+		{"itself", 2, 2, true},
+		{"child", 2, 3, true},
+		{"grandchild", 1, 4, true},
+		{"parent", 3, 2, false},
+		{"other tree", 2, 5, false},
+		{"unknown category", 2, 8, false},
+		{"existing cycle", 1, 6, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if descendant := isDescendant(categories, test.id, test.candidate); descendant != test.descendant {
+				t.Errorf("isDescendant(%d, %d) = %v, want %v", test.id, test.candidate, descendant, test.descendant)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package comparison
+
+import (
+	"context"
+
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	. "github.com/Phantomvv1/E-commerce/internal/items"
+)
+
+type ComparisonRepository struct {
+	db database.DB
+}
+
+func NewComparisonRepository(db database.DB) *ComparisonRepository {
+	return &ComparisonRepository{db: db}
+}
+
+func (r *ComparisonRepository) Contains(ctx context.Context, userID, variantID int) (bool, error) {
+	exists := false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from e_commerce.comparison where user_id = $1 and variant_id = $2)", userID, variantID).Scan(&exists)
+	return exists, err
+}
+
+func (r *ComparisonRepository) Add(ctx context.Context, userID int, variant *Variant) error {
+	_, err := r.db.Exec(ctx, "insert into e_commerce.comparison (user_id, item_id, variant_id) values ($1, $2, $3)", userID, variant.ItemID, variant.ID)
+	return err
+}
+
+func (r *ComparisonRepository) Items(ctx context.Context, userID int) ([]ItemVariant, error) {
+	rows, err := r.db.Query(ctx, "select i.id, i.name, i.description, i.price, "+VariantColumns+" from e_commerce.comparison c "+
+		"join e_commerce.variants v on v.id = c.variant_id join e_commerce.items i on i.id = v.item_id where c.user_id = $1 order by i.id, v.id", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ScanItemVariants(rows)
+}
+
+// Remove takes the variant out of the comparison, or every variant of the
+// item when variantID is nil.
+func (r *ComparisonRepository) Remove(ctx context.Context, userID, itemID int, variantID *int) (bool, error) {
+	result, err := r.db.Exec(ctx, "delete from e_commerce.comparison where user_id = $1 and item_id = $2 and ($3::int is null or variant_id = $3)", userID, itemID, variantID)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+func (r *ComparisonRepository) Clear(ctx context.Context, userID int) error {
+	_, err := r.db.Exec(ctx, "delete from e_commerce.comparison where user_id = $1", userID)
+	return err
+}
@@ -0,0 +1,265 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the key of the advisory lock held while a migration runs,
+// so several instances starting at once don't apply the same one twice.
+const migrationLock = 7_242_021
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrSchemaBehind = errors.New("Error the database schema is behind, run the migrate command")
+
+type Migration struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt"`
+	up        string
+	down      string
+}
+
+// Migrations returns every migration embedded in the binary ordered by
+// version. Each of them needs both an up and a down script.
+func Migrations() ([]Migration, error) {
+	return readMigrations(migrationFiles)
+}
+
+func readMigrations(files fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("Error invalid migration file name %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("Error there are two migrations with version %d", version)
+		}
+
+		script, err := fs.ReadFile(files, "migrations/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			migration.up = string(script)
+		} else {
+			migration.down = string(script)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, fmt.Errorf("Error migration %d needs both an up and a down script", migration.Version)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// The table of the applied migrations lives outside of e_commerce, because
+// the first migration creates that schema and its down script drops it.
+func createMigrationsTable(ctx context.Context, db DB) error {
+	_, err := db.Exec(ctx, "create table if not exists public.schema_migrations (version int primary key, name text, applied_at timestamptz default now())")
+	return err
+}
+
+func appliedMigrations(ctx context.Context, db DB) (map[int]time.Time, error) {
+	rows, err := db.Query(ctx, "select version, applied_at from public.schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		version := 0
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// MigrationStatus returns every migration along with the time it was applied
+// at, which is nil for the pending ones.
+func MigrationStatus(ctx context.Context, db DB) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = createMigrationsTable(ctx, db); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range migrations {
+		if appliedAt, ok := applied[migrations[i].Version]; ok {
+			migrations[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return migrations, nil
+}
+
+// runMigration runs one script together with the change to the table of the
+// applied migrations, so a migration which fails leaves nothing behind. It
+// reports false if another instance got to it first.
+func runMigration(ctx context.Context, db DB, migration Migration, up bool) (bool, error) {
+	ran := false
+	err := InTx(ctx, db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "select pg_advisory_xact_lock($1)", migrationLock)
+		if err != nil {
+			return err
+		}
+
+		applied := false
+		err = tx.QueryRow(ctx, "select exists (select 1 from public.schema_migrations where version = $1)", migration.Version).Scan(&applied)
+		if err != nil {
+			return err
+		}
+
+		if applied == up {
+			return nil
+		}
+
+		script := migration.down
+		if up {
+			script = migration.up
+		}
+
+		if _, err = tx.Exec(ctx, script); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		if up {
+			_, err = tx.Exec(ctx, "insert into public.schema_migrations (version, name) values ($1, $2)", migration.Version, migration.Name)
+		} else {
+			_, err = tx.Exec(ctx, "delete from public.schema_migrations where version = $1", migration.Version)
+		}
+		if err != nil {
+			return err
+		}
+
+		ran = true
+		return nil
+	})
+
+	return ran, err
+}
+
+// Migrate applies every pending migration in order and returns the ones it
+// applied.
+func Migrate(ctx context.Context, db DB) ([]Migration, error) {
+	migrations, err := MigrationStatus(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, migration := range migrations {
+		if migration.AppliedAt != nil {
+			continue
+		}
+
+		ran, err := runMigration(ctx, db, migration, true)
+		if err != nil {
+			return applied, err
+		}
+
+		if ran {
+			applied = append(applied, migration)
+		}
+	}
+
+	return applied, nil
+}
+
+// Rollback runs the down scripts of the last steps applied migrations, newest
+// first, and returns the ones it rolled back.
+func Rollback(ctx context.Context, db DB, steps int) ([]Migration, error) {
+	migrations, err := MigrationStatus(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	rolledBack := []Migration{}
+	for i := len(migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		if migrations[i].AppliedAt == nil {
+			continue
+		}
+
+		ran, err := runMigration(ctx, db, migrations[i], false)
+		if err != nil {
+			return rolledBack, err
+		}
+
+		if ran {
+			rolledBack = append(rolledBack, migrations[i])
+		}
+	}
+
+	return rolledBack, nil
+}
+
+// CheckSchema returns ErrSchemaBehind if any of the migrations the binary
+// knows about hasn't been applied yet.
+func CheckSchema(ctx context.Context, db DB) error {
+	migrations, err := MigrationStatus(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, migration := range migrations {
+		if migration.AppliedAt == nil {
+			pending++
+		}
+	}
+
+	if pending > 0 {
+		return fmt.Errorf("%w (%d pending migrations)", ErrSchemaBehind, pending)
+	}
+
+	return nil
+}
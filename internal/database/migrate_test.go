@@ -0,0 +1,75 @@
+package database
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %s has version %d, want %d", migration.Name, migration.Version, i+1)
+		}
+	}
+}
+
+func TestReadMigrations(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("select 1;")}
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int
+		err      bool
+	}{
+		{
+			"pairs",
+			fstest.MapFS{
+				"migrations/0002_second.down.sql": file,
+				"migrations/0001_first.up.sql":    file,
+				"migrations/0002_second.up.sql":   file,
+				"migrations/0001_first.down.sql":  file,
+			},
+			[]int{1, 2},
+			false,
+		},
+		{"no down script", fstest.MapFS{"migrations/0001_first.up.sql": file}, nil, true},
+		{"no up script", fstest.MapFS{"migrations/0001_first.down.sql": file}, nil, true},
+		{
+			"empty down script",
+			fstest.MapFS{"migrations/0001_first.up.sql": file, "migrations/0001_first.down.sql": &fstest.MapFile{}},
+			nil,
+			true,
+		},
+		{
+			"names don't match",
+			fstest.MapFS{"migrations/0001_first.up.sql": file, "migrations/0001_other.down.sql": file},
+			nil,
+			true,
+		},
+		{"invalid name", fstest.MapFS{"migrations/first.up.sql": file}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := readMigrations(test.files)
+			if (err != nil) != test.err {
+				t.Fatalf("readMigrations() error = %v, want error %v", err, test.err)
+			}
+
+			if len(migrations) != len(test.versions) {
+				t.Fatalf("readMigrations() returned %d migrations, want %d", len(migrations), len(test.versions))
+			}
+
+			for i, migration := range migrations {
+				if migration.Version != test.versions[i] || migration.up == "" || migration.down == "" {
+					t.Errorf("migration %d = %+v, want version %d with both scripts", i, migration, test.versions[i])
+				}
+			}
+		})
+	}
+}
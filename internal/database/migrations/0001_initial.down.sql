@@ -0,0 +1,7 @@
+drop table if exists e_commerce.wishlist;
+drop table if exists e_commerce.comparison;
+drop table if exists e_commerce.coupons;
+drop table if exists e_commerce.cart;
+drop table if exists e_commerce.items;
+drop table if exists e_commerce.authentication;
+drop schema if exists e_commerce;
@@ -0,0 +1,60 @@
+-- Databases which were set up before the migrations already have some of
+-- these tables, so they are only created when they are missing.
+create schema if not exists e_commerce;
+
+create table if not exists e_commerce.authentication (
+	id serial primary key,
+	name text,
+	email text,
+	password text,
+	type int,
+	points int,
+	tokens_revoked_at timestamptz,
+	verified boolean default true,
+	pending_email text,
+	deleted_at timestamp,
+	suspended_at timestamptz,
+	created_at timestamptz default now()
+);
+
+alter table e_commerce.authentication
+	add column if not exists tokens_revoked_at timestamptz,
+	add column if not exists verified boolean default true,
+	add column if not exists pending_email text,
+	add column if not exists deleted_at timestamp,
+	add column if not exists suspended_at timestamptz,
+	add column if not exists created_at timestamptz default now();
+
+create table if not exists e_commerce.items (
+	id serial primary key,
+	name text,
+	description text,
+	price numeric
+);
+
+create table if not exists e_commerce.cart (
+	id serial primary key,
+	item_id int references e_commerce.items(id),
+	user_id int references e_commerce.authentication(id),
+	quantity int
+);
+
+create table if not exists e_commerce.coupons (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	exp_date date,
+	discount int,
+	number int,
+	used boolean
+);
+
+create table if not exists e_commerce.comparison (
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	item_id int references e_commerce.items(id) on delete cascade
+);
+
+create table if not exists e_commerce.wishlist (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id),
+	item_id int references e_commerce.items(id)
+);
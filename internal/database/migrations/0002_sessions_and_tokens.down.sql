@@ -0,0 +1,3 @@
+drop table if exists e_commerce.revoked_tokens;
+drop table if exists e_commerce.refresh_tokens;
+drop table if exists e_commerce.sessions;
@@ -0,0 +1,26 @@
+create table if not exists e_commerce.sessions (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	ip text,
+	user_agent text,
+	created_at timestamptz default now(),
+	last_seen_at timestamptz default now(),
+	revoked_at timestamptz
+);
+
+create table if not exists e_commerce.refresh_tokens (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	session_id int references e_commerce.sessions(id) on delete cascade,
+	token_hash text unique,
+	expires_at timestamp,
+	revoked boolean default false,
+	created_at timestamp default now()
+);
+
+alter table e_commerce.refresh_tokens add column if not exists session_id int references e_commerce.sessions(id) on delete cascade;
+
+create table if not exists e_commerce.revoked_tokens (
+	jti text primary key,
+	expires_at timestamp
+);
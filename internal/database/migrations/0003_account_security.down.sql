@@ -0,0 +1,9 @@
+drop table if exists e_commerce.oauth_identities;
+drop table if exists e_commerce.oauth_states;
+drop table if exists e_commerce.two_factor_challenges;
+drop table if exists e_commerce.recovery_codes;
+drop table if exists e_commerce.two_factor;
+drop table if exists e_commerce.login_attempts;
+drop table if exists e_commerce.login_links;
+drop table if exists e_commerce.password_resets;
+drop table if exists e_commerce.email_verifications;
@@ -0,0 +1,80 @@
+create table if not exists e_commerce.email_verifications (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	email text,
+	token_hash text unique,
+	expires_at timestamp,
+	used boolean default false,
+	created_at timestamp default now()
+);
+
+create table if not exists e_commerce.password_resets (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	token_hash text unique,
+	expires_at timestamp,
+	used boolean default false,
+	created_at timestamp default now()
+);
+
+create table if not exists e_commerce.login_links (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	token_hash text unique,
+	expires_at timestamp,
+	used boolean default false,
+	created_at timestamp default now()
+);
+
+create table if not exists e_commerce.login_attempts (
+	id serial primary key,
+	email text,
+	ip text,
+	success boolean,
+	cleared boolean default false,
+	attempted_at timestamptz default now()
+);
+
+create index if not exists login_attempts_email_idx on e_commerce.login_attempts (email, attempted_at);
+
+create table if not exists e_commerce.two_factor (
+	user_id int primary key references e_commerce.authentication(id) on delete cascade,
+	secret text,
+	enabled boolean default false,
+	last_step bigint default 0,
+	created_at timestamp default now()
+);
+
+create table if not exists e_commerce.recovery_codes (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	code_hash text,
+	used boolean default false
+);
+
+create table if not exists e_commerce.two_factor_challenges (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	token_hash text unique,
+	expires_at timestamp,
+	attempts int default 0,
+	used boolean default false
+);
+
+create table if not exists e_commerce.oauth_states (
+	state_hash text primary key,
+	provider text,
+	code_verifier text,
+	nonce text,
+	expires_at timestamp
+);
+
+create table if not exists e_commerce.oauth_identities (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	provider text,
+	subject text,
+	email text,
+	created_at timestamp default now(),
+	unique (provider, subject)
+);
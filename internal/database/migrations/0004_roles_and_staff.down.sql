@@ -0,0 +1,5 @@
+drop table if exists e_commerce.impersonations;
+drop table if exists e_commerce.api_keys;
+drop table if exists e_commerce.role_changes;
+drop table if exists e_commerce.role_permissions;
+drop table if exists e_commerce.roles;
@@ -0,0 +1,57 @@
+create table if not exists e_commerce.roles (
+	id serial primary key,
+	name text unique
+);
+
+create table if not exists e_commerce.role_permissions (
+	role_id int references e_commerce.roles(id) on delete cascade,
+	permission text,
+	primary key (role_id, permission)
+);
+
+-- The ids are the account types used in the code: admin, user, catalog
+-- editor and support. The admin gets the rest of its permissions on startup.
+insert into e_commerce.roles (id, name) values (1, 'admin'), (2, 'user'), (3, 'catalog_editor'), (4, 'support') on conflict do nothing;
+
+select setval('e_commerce.roles_id_seq', greatest((select max(id) from e_commerce.roles), 1));
+
+insert into e_commerce.role_permissions (role_id, permission)
+select 3, 'items.write'
+where not exists (select 1 from e_commerce.role_permissions where role_id = 3);
+
+insert into e_commerce.role_permissions (role_id, permission)
+select 4, unnest(array['carts.read', 'orders.read', 'users.impersonate'])
+where not exists (select 1 from e_commerce.role_permissions where role_id = 4);
+
+create table if not exists e_commerce.role_changes (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	changed_by int references e_commerce.authentication(id) on delete set null,
+	old_type int,
+	new_type int,
+	changed_at timestamp default now()
+);
+
+create table if not exists e_commerce.api_keys (
+	id serial primary key,
+	name text,
+	prefix text,
+	key_hash text unique,
+	permissions text[],
+	created_by int references e_commerce.authentication(id),
+	created_at timestamptz default now(),
+	expires_at timestamptz,
+	last_used_at timestamptz,
+	revoked_at timestamptz
+);
+
+create table if not exists e_commerce.impersonations (
+	id serial primary key,
+	impersonator_id int references e_commerce.authentication(id),
+	user_id int references e_commerce.authentication(id),
+	reason text,
+	jti text,
+	ip text,
+	started_at timestamptz default now(),
+	expires_at timestamptz
+);
@@ -0,0 +1,2 @@
+drop table if exists e_commerce.sent_emails;
+drop table if exists e_commerce.points_history;
@@ -0,0 +1,14 @@
+create table if not exists e_commerce.points_history (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	points int,
+	reason text,
+	created_at timestamp default now()
+);
+
+create table if not exists e_commerce.sent_emails (
+	id serial primary key,
+	recipient text,
+	subject text,
+	sent_at timestamp default now()
+);
@@ -0,0 +1,2 @@
+drop table if exists e_commerce.audit_log;
+drop function if exists e_commerce.audit_log_append_only();
@@ -0,0 +1,25 @@
+create table if not exists e_commerce.audit_log (
+	id bigserial primary key,
+	actor_id int,
+	api_key_id int,
+	action text,
+	target_type text,
+	target_id text,
+	changes jsonb,
+	ip text,
+	created_at timestamptz default now()
+);
+
+-- The audit log is append-only, every update, delete or truncate is refused.
+create or replace function e_commerce.audit_log_append_only() returns trigger language plpgsql as $$
+begin
+	raise exception 'the audit log is append-only';
+end $$;
+
+drop trigger if exists audit_log_append_only on e_commerce.audit_log;
+create trigger audit_log_append_only before update or delete on e_commerce.audit_log
+	for each row execute function e_commerce.audit_log_append_only();
+
+drop trigger if exists audit_log_no_truncate on e_commerce.audit_log;
+create trigger audit_log_no_truncate before truncate on e_commerce.audit_log
+	for each statement execute function e_commerce.audit_log_append_only();
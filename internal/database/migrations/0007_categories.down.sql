@@ -0,0 +1,2 @@
+drop table if exists e_commerce.item_categories;
+drop table if exists e_commerce.categories;
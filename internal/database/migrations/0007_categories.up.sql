@@ -0,0 +1,18 @@
+create table e_commerce.categories (
+	id serial primary key,
+	parent_id int references e_commerce.categories(id),
+	name text not null,
+	slug text not null unique,
+	position int not null default 0,
+	created_at timestamptz default now()
+);
+
+create index categories_parent_idx on e_commerce.categories (parent_id, position);
+
+create table e_commerce.item_categories (
+	item_id int references e_commerce.items(id) on delete cascade,
+	category_id int references e_commerce.categories(id) on delete cascade,
+	primary key (item_id, category_id)
+);
+
+create index item_categories_category_idx on e_commerce.item_categories (category_id);
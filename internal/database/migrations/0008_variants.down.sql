@@ -0,0 +1,5 @@
+alter table e_commerce.comparison drop column if exists variant_id;
+alter table e_commerce.wishlist drop column if exists variant_id;
+alter table e_commerce.cart drop column if exists variant_id;
+drop table if exists e_commerce.variants;
+drop table if exists e_commerce.item_options;
@@ -0,0 +1,40 @@
+-- The option axes of an item, like size or color, with the values they can
+-- have.
+create table e_commerce.item_options (
+	id serial primary key,
+	item_id int not null references e_commerce.items(id) on delete cascade,
+	name text not null,
+	"values" text[] not null,
+	position int not null default 0,
+	unique (item_id, name)
+);
+
+-- Every item has at least one variant, which is what's actually sold. The
+-- price of a variant is the price of its item unless it's overridden.
+create table e_commerce.variants (
+	id serial primary key,
+	item_id int not null references e_commerce.items(id) on delete cascade,
+	sku text not null unique,
+	options jsonb not null default '{}',
+	price numeric,
+	stock int not null default 0 check (stock >= 0),
+	created_at timestamptz default now(),
+	unique (item_id, options)
+);
+
+insert into e_commerce.variants (item_id, sku) select id, 'ITEM-' || id from e_commerce.items;
+
+alter table e_commerce.cart add column variant_id int references e_commerce.variants(id) on delete cascade;
+update e_commerce.cart c set variant_id = v.id from e_commerce.variants v where v.item_id = c.item_id;
+delete from e_commerce.cart where variant_id is null;
+alter table e_commerce.cart alter column variant_id set not null;
+
+alter table e_commerce.wishlist add column variant_id int references e_commerce.variants(id) on delete cascade;
+update e_commerce.wishlist w set variant_id = v.id from e_commerce.variants v where v.item_id = w.item_id;
+delete from e_commerce.wishlist where variant_id is null;
+alter table e_commerce.wishlist alter column variant_id set not null;
+
+alter table e_commerce.comparison add column variant_id int references e_commerce.variants(id) on delete cascade;
+update e_commerce.comparison c set variant_id = v.id from e_commerce.variants v where v.item_id = c.item_id;
+delete from e_commerce.comparison where variant_id is null;
+alter table e_commerce.comparison alter column variant_id set not null;
@@ -0,0 +1,3 @@
+delete from e_commerce.role_permissions where permission = 'inventory.write';
+drop table if exists e_commerce.stock_reservations;
+drop table if exists e_commerce.stock_movements;
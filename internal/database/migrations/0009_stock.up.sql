@@ -0,0 +1,33 @@
+-- Every change to the stock of a variant, with the stock it left behind.
+create table e_commerce.stock_movements (
+	id bigserial primary key,
+	variant_id int not null references e_commerce.variants(id) on delete cascade,
+	change int not null,
+	stock_after int not null,
+	reason text not null,
+	reservation_id int,
+	actor_id int references e_commerce.authentication(id) on delete set null,
+	created_at timestamptz default now()
+);
+
+create index stock_movements_variant_idx on e_commerce.stock_movements (variant_id, created_at);
+
+insert into e_commerce.stock_movements (variant_id, change, stock_after, reason)
+select id, stock, stock, 'initial' from e_commerce.variants where stock > 0;
+
+-- Stock held for a checkout until it's paid for, it fails or it expires.
+create table e_commerce.stock_reservations (
+	id serial primary key,
+	user_id int references e_commerce.authentication(id) on delete cascade,
+	variant_id int not null references e_commerce.variants(id) on delete cascade,
+	quantity int not null check (quantity > 0),
+	payment_intent text,
+	status text not null default 'active',
+	expires_at timestamptz not null,
+	created_at timestamptz default now()
+);
+
+create index stock_reservations_active_idx on e_commerce.stock_reservations (variant_id) where status = 'active';
+create index stock_reservations_payment_idx on e_commerce.stock_reservations (payment_intent);
+
+insert into e_commerce.role_permissions (role_id, permission) values (3, 'inventory.write') on conflict do nothing;
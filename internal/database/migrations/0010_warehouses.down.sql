@@ -0,0 +1,5 @@
+alter table e_commerce.stock_reservations drop column if exists warehouse_id;
+alter table e_commerce.stock_movements drop column if exists transfer_id, drop column if exists warehouse_id;
+drop table if exists e_commerce.stock_transfers;
+drop table if exists e_commerce.warehouse_stock;
+drop table if exists e_commerce.warehouses;
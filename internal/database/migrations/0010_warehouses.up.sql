@@ -0,0 +1,49 @@
+-- Where the stock is held. The stock of a variant is now the sum of its stock
+-- in every warehouse, and checkouts are fulfilled from the warehouses with the
+-- lowest priority first.
+create table e_commerce.warehouses (
+	id serial primary key,
+	code text not null unique,
+	name text not null,
+	address text not null default '',
+	priority int not null default 0,
+	created_at timestamptz default now()
+);
+
+create table e_commerce.warehouse_stock (
+	warehouse_id int not null references e_commerce.warehouses(id) on delete restrict,
+	variant_id int not null references e_commerce.variants(id) on delete cascade,
+	stock int not null default 0 check (stock >= 0),
+	primary key (warehouse_id, variant_id)
+);
+
+create index warehouse_stock_variant_idx on e_commerce.warehouse_stock (variant_id);
+
+-- Everything there is already in stock is in the main warehouse.
+insert into e_commerce.warehouses (code, name) values ('MAIN', 'Main warehouse');
+
+insert into e_commerce.warehouse_stock (warehouse_id, variant_id, stock)
+select w.id, v.id, v.stock from e_commerce.variants v, e_commerce.warehouses w where w.code = 'MAIN' and v.stock > 0;
+
+create table e_commerce.stock_transfers (
+	id serial primary key,
+	variant_id int not null references e_commerce.variants(id) on delete cascade,
+	from_warehouse_id int not null references e_commerce.warehouses(id) on delete restrict,
+	to_warehouse_id int not null references e_commerce.warehouses(id) on delete restrict,
+	quantity int not null check (quantity > 0),
+	actor_id int references e_commerce.authentication(id) on delete set null,
+	created_at timestamptz default now(),
+	check (from_warehouse_id <> to_warehouse_id)
+);
+
+alter table e_commerce.stock_movements
+	add column warehouse_id int references e_commerce.warehouses(id) on delete restrict,
+	add column transfer_id int references e_commerce.stock_transfers(id) on delete set null;
+
+alter table e_commerce.stock_reservations add column warehouse_id int references e_commerce.warehouses(id) on delete restrict;
+
+update e_commerce.stock_movements set warehouse_id = (select id from e_commerce.warehouses where code = 'MAIN');
+update e_commerce.stock_reservations set warehouse_id = (select id from e_commerce.warehouses where code = 'MAIN');
+
+alter table e_commerce.stock_movements alter column warehouse_id set not null;
+alter table e_commerce.stock_reservations alter column warehouse_id set not null;
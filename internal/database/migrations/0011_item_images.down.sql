@@ -0,0 +1 @@
+drop table if exists e_commerce.item_images;
@@ -0,0 +1,16 @@
+-- The gallery of an item, in the order of position. keys are every file of
+-- the image in the storage, the original and its thumbnails.
+create table e_commerce.item_images (
+	id serial primary key,
+	item_id int not null references e_commerce.items(id) on delete cascade,
+	position int not null default 0,
+	alt text not null default '',
+	url text not null,
+	width int not null,
+	height int not null,
+	thumbnails jsonb not null default '{}',
+	keys text[] not null,
+	created_at timestamptz default now()
+);
+
+create index item_images_item_idx on e_commerce.item_images (item_id, position);
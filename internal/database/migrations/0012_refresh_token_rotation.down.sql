@@ -0,0 +1 @@
+alter table e_commerce.refresh_tokens drop column if exists rotated_at;
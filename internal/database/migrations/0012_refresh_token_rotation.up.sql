@@ -0,0 +1,3 @@
+-- When a refresh token was exchanged for a new one. Only a rotated token
+-- being used again means it was stolen, unlike one revoked by a logout.
+alter table e_commerce.refresh_tokens add column rotated_at timestamptz;
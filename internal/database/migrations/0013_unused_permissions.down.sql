@@ -0,0 +1,7 @@
+insert into e_commerce.role_permissions (role_id, permission)
+select id, unnest(array['orders.read', 'coupons.write']) from e_commerce.roles where id = 1
+on conflict do nothing;
+
+insert into e_commerce.role_permissions (role_id, permission)
+select id, 'orders.read' from e_commerce.roles where id = 4
+on conflict do nothing;
@@ -0,0 +1,6 @@
+-- Nothing checks these permissions, there are no orders apart from the cart
+-- and coupons are added by their users.
+delete from e_commerce.role_permissions where permission in ('orders.read', 'coupons.write');
+
+update e_commerce.api_keys set permissions = array_remove(array_remove(permissions, 'orders.read'), 'coupons.write')
+where permissions && array['orders.read', 'coupons.write'];
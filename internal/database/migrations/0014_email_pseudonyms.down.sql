@@ -0,0 +1 @@
+-- The emails can't be recovered from their pseudonyms, so they stay as they are.
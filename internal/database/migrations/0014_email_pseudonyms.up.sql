@@ -0,0 +1,10 @@
+-- Login attempts and the audit entries about them keep a sha256 of the
+-- lowercased email instead of the email, see emailPseudonym.
+update e_commerce.login_attempts set email = encode(sha256(convert_to(lower(email), 'UTF8')), 'hex') where email like '%@%';
+
+alter table e_commerce.audit_log disable trigger audit_log_append_only;
+
+update e_commerce.audit_log set target_id = encode(sha256(convert_to(lower(target_id), 'UTF8')), 'hex')
+where action in ('auth.login_failed', 'admin.unlock') and target_id like '%@%';
+
+alter table e_commerce.audit_log enable trigger audit_log_append_only;
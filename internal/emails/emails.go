@@ -1,41 +1,58 @@
 package emails
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
 	"os"
 
-	. "github.com/Phantomvv1/E-commerce/internal/authentication"
+	"github.com/Phantomvv1/E-commerce/internal/database"
 	"github.com/gin-gonic/gin"
 	"gopkg.in/gomail.v2"
 )
 
-func SendEmail(c *gin.Context) {
-	var information map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&information) // token && subject && text
+// Only the recipient and the subject are kept, the body of an email can
+// contain single-use links.
+func recordSentEmail(ctx context.Context, db database.DB, to, subject string) error {
+	_, err := db.Exec(ctx, "insert into e_commerce.sent_emails (recipient, subject) values ($1, $2)", to, subject)
+	return err
+}
 
-	token, ok := information["token"]
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
+func Send(ctx context.Context, db database.DB, to, subject, text string) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", os.Getenv("SMTP_USERNAME"))
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/plain", text)
 
-	_, _, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
-		return
+	dialer := gomail.NewDialer(os.Getenv("SMTP_FROM"), 465, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	if err := dialer.DialAndSend(m); err != nil {
+		return err
 	}
 
-	email, err := GetEmail(token)
-	if err != nil {
+	if err := recordSentEmail(ctx, db, to, subject); err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error couldn't parse your token correctly"})
-		return
 	}
 
+	return nil
+}
+
+type EmailHandler struct {
+	db database.DB
+}
+
+func NewEmailHandler(db database.DB) *EmailHandler {
+	return &EmailHandler{db: db}
+}
+
+func (h *EmailHandler) SendEmail(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // subject && text
+
+	// The email of the user is put in the context by the authentication middleware.
+	email := c.GetString("email")
+
 	subject, ok := information["subject"]
 	if !ok {
 		log.Println("Incorrectly provided subject of the email")
@@ -50,15 +67,7 @@ func SendEmail(c *gin.Context) {
 		return
 	}
 
-	m := gomail.NewMessage()
-	m.SetHeader("From", os.Getenv("SMTP_USERNAME"))
-	m.SetHeader("To", email)
-	m.SetHeader("Subject", subject)
-	m.SetBody("text/plain", text)
-
-	dialer := gomail.NewDialer(os.Getenv("SMTP_FROM"), 465, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
-
-	if err = dialer.DialAndSend(m); err != nil {
+	if err := Send(c.Request.Context(), h.db, email, subject, text); err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to send the email to the person"})
 		return
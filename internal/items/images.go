@@ -0,0 +1,431 @@
+package items
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	"github.com/Phantomvv1/E-commerce/internal/media"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxImageSize = 10 << 20
+	// maxImagePixels keeps a small file which decodes into a huge image from
+	// taking all of the memory.
+	maxImagePixels = 40_000_000
+)
+
+// thumbnailSizes are the thumbnails made of every image, by the longest side
+// they can have.
+var thumbnailSizes = []struct {
+	name string
+	size int
+}{
+	{"small", 200},
+	{"medium", 800},
+}
+
+var (
+	errNoSuchImage      = errors.New("Error there is no image with this id")
+	errImagesDontMatch  = errors.New("Error the order has to have every image of the item exactly once")
+	errUnsupportedImage = errors.New("Error the image has to be a JPEG, PNG or GIF")
+)
+
+// Image is one picture of the gallery of an item. Thumbnails are the URLs of
+// the smaller versions of it by their name.
+type Image struct {
+	ID         int               `json:"id"`
+	ItemID     int               `json:"itemID"`
+	Position   int               `json:"position"`
+	Alt        string            `json:"alt"`
+	URL        string            `json:"url"`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+	Thumbnails map[string]string `json:"thumbnails"`
+	keys       []string
+}
+
+// AddImage puts the image at the end of the gallery of its item.
+func (r *ItemRepository) AddImage(ctx context.Context, image *Image) error {
+	return r.db.QueryRow(ctx, "insert into e_commerce.item_images (item_id, position, alt, url, width, height, thumbnails, keys) "+
+		"select $1, coalesce(max(position) + 1, 0), $2, $3, $4, $5, $6, $7 from e_commerce.item_images where item_id = $1 returning id, position",
+		image.ItemID, image.Alt, image.URL, image.Width, image.Height, image.Thumbnails, image.keys).Scan(&image.ID, &image.Position)
+}
+
+// Images returns the galleries of the items by the id of their item.
+func (r *ItemRepository) Images(ctx context.Context, itemIDs ...int) (map[int][]Image, error) {
+	rows, err := r.db.Query(ctx, "select id, item_id, position, alt, url, width, height, thumbnails, keys from e_commerce.item_images "+
+		"where item_id = any($1) order by item_id, position, id", itemIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := map[int][]Image{}
+	for rows.Next() {
+		image := Image{}
+		err = rows.Scan(&image.ID, &image.ItemID, &image.Position, &image.Alt, &image.URL, &image.Width, &image.Height, &image.Thumbnails, &image.keys)
+		if err != nil {
+			return nil, err
+		}
+
+		images[image.ItemID] = append(images[image.ItemID], image)
+	}
+
+	return images, rows.Err()
+}
+
+// OrderImages puts the gallery of the item in the order of imageIDs, which
+// has to have every image of the item.
+func (r *ItemRepository) OrderImages(ctx context.Context, itemID int, imageIDs []int) error {
+	return database.InTx(ctx, r.db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, "select id from e_commerce.item_images where item_id = $1 for update", itemID)
+		if err != nil {
+			return err
+		}
+
+		current := []int{}
+		for rows.Next() {
+			id := 0
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+
+			current = append(current, id)
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		ordered := slices.Clone(imageIDs)
+		slices.Sort(current)
+		slices.Sort(ordered)
+		if !slices.Equal(current, ordered) {
+			return errImagesDontMatch
+		}
+
+		_, err = tx.Exec(ctx, "update e_commerce.item_images i set position = o.position - 1 from unnest($1::int[]) with ordinality o(id, position) where i.id = o.id",
+			imageIDs)
+		return err
+	})
+}
+
+// DeleteImage returns the image as it was before it was deleted, so that its
+// files can be deleted too.
+func (r *ItemRepository) DeleteImage(ctx context.Context, id int) (*Image, error) {
+	image := &Image{}
+	err := r.db.QueryRow(ctx, "delete from e_commerce.item_images where id = $1 returning id, item_id, position, alt, url, width, height, thumbnails, keys", id).
+		Scan(&image.ID, &image.ItemID, &image.Position, &image.Alt, &image.URL, &image.Width, &image.Height, &image.Thumbnails, &image.keys)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, errNoSuchImage
+		}
+
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// withImages fills in the galleries of the items.
+func (r *ItemRepository) withImages(ctx context.Context, items []Item) error {
+	ids := []int{}
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+
+	images, err := r.Images(ctx, ids...)
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		items[i].Images = images[items[i].ID]
+	}
+
+	return nil
+}
+
+// WithImages fills in the galleries of the items of the entries.
+func (r *ItemRepository) WithImages(ctx context.Context, entries []ItemVariant) error {
+	ids := []int{}
+	for _, entry := range entries {
+		ids = append(ids, entry.Item.ID)
+	}
+
+	images, err := r.Images(ctx, ids...)
+	if err != nil {
+		return err
+	}
+
+	for i := range entries {
+		entries[i].Item.Images = images[entries[i].Item.ID]
+	}
+
+	return nil
+}
+
+// deleteFiles removes the files of the images from the storage. The images
+// are already gone from the database, so a file which can't be deleted is
+// only logged.
+func (h *ItemHandler) deleteFiles(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := h.storage.Delete(ctx, key); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// storeImage puts the original and the thumbnails of an image in the storage
+// under a new random name.
+func (h *ItemHandler) storeImage(ctx context.Context, stored *Image, decoded image.Image, format string, data []byte) error {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("items/%d/%s", stored.ItemID, hex.EncodeToString(random))
+
+	extension, contentType := format, "image/"+format
+	if format == "jpeg" {
+		extension = "jpg"
+	}
+
+	var err error
+	stored.URL, err = h.storage.Put(ctx, name+"."+extension, data, contentType)
+	if err != nil {
+		return err
+	}
+	stored.keys = append(stored.keys, name+"."+extension)
+
+	// Only JPEGs have no transparency to lose, so the rest get PNG thumbnails.
+	extension, contentType = "png", "image/png"
+	if format == "jpeg" {
+		extension, contentType = "jpg", "image/jpeg"
+	}
+
+	stored.Thumbnails = map[string]string{}
+	for _, thumbnail := range thumbnailSizes {
+		buffer := bytes.Buffer{}
+		scaled := media.Fit(decoded, thumbnail.size)
+		if format == "jpeg" {
+			err = jpeg.Encode(&buffer, scaled, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buffer, scaled)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		key := name + "-" + thumbnail.name + "." + extension
+		if stored.Thumbnails[thumbnail.name], err = h.storage.Put(ctx, key, buffer.Bytes(), contentType); err != nil {
+			return err
+		}
+		stored.keys = append(stored.keys, key)
+	}
+
+	return nil
+}
+
+// UploadItemImage adds the image in the multipart form field image to the end
+// of the gallery of the item in itemID, with thumbnails of it. The form can
+// also have the alt text of the image in alt.
+func (h *ItemHandler) UploadItemImage(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageSize+1<<20)
+
+	itemID, err := strconv.Atoi(c.PostForm("itemID"))
+	if err != nil {
+		log.Println("Incorrectly provided id of the item")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the item"})
+		return
+	}
+
+	header, err := c.FormFile("image")
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error the image has to be uploaded in the field image of a multipart form of at most 10MB"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to read the image"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxImageSize+1))
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to read the image"})
+		return
+	}
+
+	if len(data) > maxImageSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Error the image can't be bigger than 10MB"})
+		return
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errUnsupportedImage.Error()})
+		return
+	}
+
+	if config.Width*config.Height > maxImagePixels {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error the image can't have more than 40 megapixels"})
+		return
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errUnsupportedImage.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	exists, err := h.items.Exists(ctx, itemID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no item with this id"})
+		return
+	}
+
+	uploaded := Image{ItemID: itemID, Alt: strings.TrimSpace(c.PostForm("alt")), Width: config.Width, Height: config.Height}
+	if err = h.storeImage(ctx, &uploaded, decoded, format, data); err != nil {
+		log.Println(err)
+		h.deleteFiles(ctx, uploaded.keys)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to store the image"})
+		return
+	}
+
+	err = h.items.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.AddImage(ctx, &uploaded); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "item.image.add", "item", itemID, nil, uploaded)
+	})
+	if err != nil {
+		log.Println(err)
+		h.deleteFiles(ctx, uploaded.keys)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"image": uploaded})
+}
+
+// OrderItemImages reorders the gallery of an item. imageIDs has every image of
+// the item, the first one being the main image.
+func (h *ItemHandler) OrderItemImages(c *gin.Context) {
+	var information struct {
+		ItemID   *int  `json:"itemID"`
+		ImageIDs []int `json:"imageIDs"`
+	}
+	json.NewDecoder(c.Request.Body).Decode(&information) // itemID && imageIDs
+
+	if information.ItemID == nil {
+		log.Println("Incorrectly provided id of the item")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the item"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	before, err := h.items.Images(ctx, *information.ItemID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	order := []int{}
+	for _, image := range before[*information.ItemID] {
+		order = append(order, image.ID)
+	}
+
+	err = h.items.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.OrderImages(ctx, *information.ItemID, information.ImageIDs); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "item.image.order", "item", *information.ItemID, order, information.ImageIDs)
+	})
+	if err != nil {
+		if err == errImagesDontMatch {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (h *ItemHandler) DeleteItemImage(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id
+
+	id, ok := information["id"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the image")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the image"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var deleted *Image
+	err := h.items.inTx(ctx, func(tx *ItemRepository) error {
+		image, err := tx.DeleteImage(ctx, int(id))
+		if err != nil {
+			return err
+		}
+		deleted = image
+
+		return audit.Record(c, tx.db, "item.image.delete", "item", deleted.ItemID, deleted, nil)
+	})
+	if err != nil {
+		if err == errNoSuchImage {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to delete the image from the database"})
+		return
+	}
+
+	h.deleteFiles(ctx, deleted.keys)
+
+	c.JSON(http.StatusOK, nil)
+}
@@ -1,67 +1,43 @@
 package items
 
 import (
-	"context"
 	"encoding/json"
 	"log"
-	"math/rand/v2"
 	"net/http"
-	"os"
+	"strings"
 
-	. "github.com/Phantomvv1/E-commerce/internal/authentication"
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	"github.com/Phantomvv1/E-commerce/internal/media"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
 )
 
 type Item struct {
-	ID          int     `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float32 `json:"price"`
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Price       float32      `json:"price"`
+	Options     []ItemOption `json:"options,omitempty"`
+	Variants    []Variant    `json:"variants,omitempty"`
+	Images      []Image      `json:"images,omitempty"`
 }
 
-func CreateItemsTable(conn *pgx.Conn) error {
-	_, err := conn.Exec(context.Background(), "create table if not exists e_commerce.items (id serial primary key, name text, description text, price numeric)")
-	return err
+type ItemHandler struct {
+	db      database.DB
+	items   *ItemRepository
+	storage media.Storage
 }
 
-func CreateItem(c *gin.Context) {
-	var information map[string]interface{}
-	json.NewDecoder(c.Request.Body).Decode(&information) // token && name && description && price
-
-	token, ok := information["token"].(string)
-	if !ok {
-		log.Println("Token provided incorrectly")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error token provided incorrectly"})
-		return
-	}
-
-	_, accountType, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
-		return
-	}
-
-	if accountType != Admin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Error only admins can create items"})
-		return
-	}
-
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
-		return
-	}
-	defer conn.Close(context.Background())
+func NewItemHandler(db database.DB, storage media.Storage) *ItemHandler {
+	return &ItemHandler{db: db, items: NewItemRepository(db), storage: storage}
+}
 
-	if err = CreateItemsTable(conn); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to create a table for the items"})
-		return
-	}
+func (h *ItemHandler) CreateItem(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // name && description && price && (sku || stock)
 
+	ctx := c.Request.Context()
 	name, ok := information["name"].(string)
 	if !ok {
 		log.Println("Incorrectly provided name of the item")
@@ -83,46 +59,38 @@ func CreateItem(c *gin.Context) {
 		return
 	}
 
-	_, err = conn.Exec(context.Background(), "insert into e_commerce.items (name, description, price) values ($1, $2, $3)", name, desc, price)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information about the item in the database"})
+	sku, _ := information["sku"].(string)
+	stock, _ := information["stock"].(float64)
+	if stock < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error the stock can't be negative"})
 		return
 	}
 
-	c.JSON(http.StatusOK, nil)
-}
-
-func UpdateItem(c *gin.Context) {
-	var information map[string]interface{}
-	json.NewDecoder(c.Request.Body).Decode(&information) // token && id && (name || desc, || price)
-
-	token, ok := information["token"].(string)
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
+	item := Item{Name: name, Description: desc, Price: float32(price)}
+	err := h.items.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.Create(ctx, &item, strings.TrimSpace(sku), int(stock), c.GetInt("id")); err != nil {
+			return err
+		}
 
-	_, accountType, err := ValidateJWT(token)
+		return audit.Record(c, tx.db, "item.create", "item", item.ID, nil, item)
+	})
 	if err != nil {
+		if err = variantError(err); err == errSKUTaken {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
 		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information about the item in the database"})
 		return
 	}
 
-	if accountType != Admin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Error only admins can update items"})
-		return
-	}
+	c.JSON(http.StatusOK, nil)
+}
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
-		return
-	}
-	defer conn.Close(context.Background())
+func (h *ItemHandler) UpdateItem(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id && (name || desc, || price)
 
 	id, ok := information["id"].(float64)
 	if !ok {
@@ -132,60 +100,62 @@ func UpdateItem(c *gin.Context) {
 	}
 	itemID := int(id)
 
-	updateName := true
-	name, ok := information["name"].(string)
-	if !ok {
-		updateName = false
+	name, updateName := information["name"].(string)
+	desc, updateDesc := information["description"].(string)
+	price, updatePrice := information["price"].(float64)
+	if !updateName && !updateDesc && !updatePrice {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error not enough information to update the item with"})
+		return
 	}
 
-	updateDesc := true
-	desc, ok := information["description"].(string)
-	if !ok {
-		updateDesc = false
-	}
+	ctx := c.Request.Context()
+	before, err := h.items.Get(ctx, itemID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no item with this id"})
+			return
+		}
 
-	updatePrice := true
-	price, ok := information["price"].(float64)
-	if !ok && !updateDesc && !updateName {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error not enough information to update the item with"})
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
-	} else if !ok {
-		updatePrice = false
 	}
 
+	after := *before
 	if updateName {
-		_, err = conn.Exec(context.Background(), "update e_commerce.items set name = $1 where id = $2", name, itemID)
-		if err != nil {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
-			return
-		}
+		after.Name = name
 	}
 
 	if updateDesc {
-		_, err = conn.Exec(context.Background(), "update e_commerce.items set description = $1 where id = $2", desc, itemID)
-		if err != nil {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
-			return
-		}
-
+		after.Description = desc
 	}
 
 	if updatePrice {
-		_, err = conn.Exec(context.Background(), "update e_commerce.items set price = $1 where id = $2", price, itemID)
-		if err != nil {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		after.Price = float32(price)
+	}
+
+	err = h.items.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.Update(ctx, &after); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "item.update", "item", itemID, before, after)
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no item with this id"})
 			return
 		}
 
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
 	}
 
 	c.JSON(http.StatusOK, nil)
 }
 
-func GetItemByID(c *gin.Context) {
+func (h *ItemHandler) GetItemByID(c *gin.Context) {
 	var information map[string]int
 	json.NewDecoder(c.Request.Body).Decode(&information)
 
@@ -196,17 +166,8 @@ func GetItemByID(c *gin.Context) {
 		return
 	}
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
-		return
-	}
-	defer conn.Close(context.Background())
-
-	item := Item{}
-	item.ID = id
-	err = conn.QueryRow(context.Background(), "select name, description, price from e_commerce.items where id = $1", item.ID).Scan(&item.Name, &item.Description, &item.Price)
+	ctx := c.Request.Context()
+	item, err := h.items.Get(ctx, id)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			log.Println(err)
@@ -219,129 +180,112 @@ func GetItemByID(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"item": item})
-}
-
-func SearchForItem(c *gin.Context) {
-	var information map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&information)
-
-	name, ok := information["name"]
-	if !ok {
-		log.Println("Incorrectly provided name of the item")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided name of the item"})
+	if item.Options, err = h.items.Options(ctx, id); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
 	}
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
+	if item.Variants, err = h.items.Variants(ctx, id); err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
 	}
-	defer conn.Close(context.Background())
 
-	rows, err := conn.Query(context.Background(), "select id, name, description, price from e_commerce.items i where i.name ~ $1", name)
+	images, err := h.items.Images(ctx, id)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
 	}
+	item.Images = images[id]
 
-	var items []Item
-	for rows.Next() {
-		item := Item{}
-		err = rows.Scan(&item.ID, &item.Name, &item.Description, &item.Price)
-		if err != nil {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error working with the information from the database"})
-			return
-		}
+	c.JSON(http.StatusOK, gin.H{"item": item})
+}
 
-		items = append(items, item)
-	}
+func (h *ItemHandler) SearchForItem(c *gin.Context) {
+	var information map[string]string
+	json.NewDecoder(c.Request.Body).Decode(&information) // name && category?
 
-	if rows.Err() != nil {
-		log.Println(rows.Err())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error working with the information from the database"})
+	name, ok := information["name"]
+	if !ok {
+		log.Println("Incorrectly provided name of the item")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided name of the item"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"items": items})
-}
-
-func GetAllItems(c *gin.Context) {
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
+	ctx := c.Request.Context()
+	if !h.categoryExists(c, information["category"]) {
 		return
 	}
-	defer conn.Close(context.Background())
 
-	rows, err := conn.Query(context.Background(), "select id, name, description, price from e_commerce.items order by id")
+	items, err := h.items.Search(ctx, name, information["category"])
+	if err == nil {
+		err = h.items.withImages(ctx, items)
+	}
+
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
 	}
 
-	var items []Item
-	for rows.Next() {
-		item := Item{}
-		err = rows.Scan(&item.ID, &item.Name, &item.Description, &item.Price)
-		if err != nil {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error working with the items"})
-			return
-		}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
 
-		items = append(items, item)
+// categoryExists answers the request itself when the category to filter by
+// doesn't exist. An empty category means no filter.
+func (h *ItemHandler) categoryExists(c *gin.Context, category string) bool {
+	if category == "" {
+		return true
 	}
 
-	if rows.Err() != nil {
+	exists, err := h.items.CategoryExists(c.Request.Context(), category)
+	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error working with the items"})
-		return
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return false
 	}
 
-	c.JSON(http.StatusOK, gin.H{"items": items})
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no category with this slug"})
+		return false
+	}
+
+	return true
 }
 
-func GetRandomItem(c *gin.Context) {
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
+// GetAllItems returns every item, or with ?category=slug only the ones in
+// that category and the categories under it.
+func (h *ItemHandler) GetAllItems(c *gin.Context) {
+	category := c.Query("category")
+	if !h.categoryExists(c, category) {
 		return
 	}
-	defer conn.Close(context.Background())
 
-	rows, err := conn.Query(context.Background(), "select id from e_commerce.items")
+	ctx := c.Request.Context()
+	items, err := h.items.All(ctx, category)
+	if err == nil {
+		err = h.items.withImages(ctx, items)
+	}
+
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
 	}
 
-	var IDarr []int
-	for rows.Next() {
-		id := 0
-		err = rows.Scan(&id)
-		if err != nil {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting information from the database"})
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *ItemHandler) GetRandomItem(c *gin.Context) {
+	item, err := h.items.Random(c.Request.Context())
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there are no items in the shop"})
 			return
 		}
 
-		IDarr = append(IDarr, id)
-	}
-
-	item := Item{}
-	item.ID = IDarr[rand.IntN(len(IDarr))]
-
-	err = conn.QueryRow(context.Background(), "select name, description, price from e_commerce.items where id = $1", item.ID).Scan(&item.Name, &item.Description, &item.Price)
-	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
@@ -350,46 +294,35 @@ func GetRandomItem(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"item": item})
 }
 
-func DeleteItem(c *gin.Context) {
+func (h *ItemHandler) DeleteItem(c *gin.Context) {
 	var information map[string]interface{}
-	json.NewDecoder(c.Request.Body).Decode(&information) // token && id
+	json.NewDecoder(c.Request.Body).Decode(&information) // id
 
-	token, ok := information["token"].(string)
+	id, ok := information["id"].(float64)
 	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
-
-	_, accountType, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
-		return
-	}
-
-	if accountType != Admin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Error only admins can delete items"})
+		log.Println("Incorrectly provided id of the item")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the item"})
 		return
 	}
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+	ctx := c.Request.Context()
+	images, err := h.items.Images(ctx, int(id))
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
 	}
-	defer conn.Close(context.Background())
 
-	id, ok := information["id"].(float64)
-	if !ok {
-		log.Println("Incorrectly provided id of the item")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the item"})
-		return
-	}
+	var item *Item
+	err = h.items.inTx(ctx, func(tx *ItemRepository) error {
+		deleted, err := tx.Delete(ctx, int(id))
+		if err != nil {
+			return err
+		}
+		item = deleted
 
-	check := 0
-	err = conn.QueryRow(context.Background(), "delete from e_commerce.items where id = $1 returning id", id).Scan(&check)
+		return audit.Record(c, tx.db, "item.delete", "item", item.ID, item, nil)
+	})
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no item with this id"})
@@ -401,20 +334,15 @@ func DeleteItem(c *gin.Context) {
 		return
 	}
 
+	for _, image := range images[item.ID] {
+		h.deleteFiles(ctx, image.keys)
+	}
+
 	c.JSON(http.StatusOK, nil)
 }
 
-func CountItems(c *gin.Context) {
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
-		return
-	}
-	defer conn.Close(context.Background())
-
-	count := 0
-	err = conn.QueryRow(context.Background(), "select count(*) from e_commerce.items").Scan(&count)
+func (h *ItemHandler) CountItems(c *gin.Context) {
+	count, err := h.items.Count(c.Request.Context())
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
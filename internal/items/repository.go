@@ -0,0 +1,166 @@
+package items
+
+import (
+	"context"
+
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	"github.com/jackc/pgx/v5"
+)
+
+type ItemRepository struct {
+	db database.DB
+}
+
+func NewItemRepository(db database.DB) *ItemRepository {
+	return &ItemRepository{db: db}
+}
+
+// inTx runs fn with a repository bound to a transaction.
+func (r *ItemRepository) inTx(ctx context.Context, fn func(tx *ItemRepository) error) error {
+	return database.InTx(ctx, r.db, func(tx pgx.Tx) error {
+		return fn(&ItemRepository{db: tx})
+	})
+}
+
+func scanItems(rows pgx.Rows) ([]Item, error) {
+	defer rows.Close()
+
+	items := []Item{}
+	for rows.Next() {
+		item := Item{}
+		if err := rows.Scan(&item.ID, &item.Name, &item.Description, &item.Price); err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// Create adds the item together with its first variant, which gets the SKU
+// ITEM-<id> if sku is empty. The initial stock goes into the default
+// warehouse and is recorded in the ledger as done by actorID.
+func (r *ItemRepository) Create(ctx context.Context, item *Item, sku string, stock, actorID int) error {
+	return r.inTx(ctx, func(tx *ItemRepository) error {
+		variantID := 0
+		err := tx.db.QueryRow(ctx, "with item as (insert into e_commerce.items (name, description, price) values ($1, $2, $3) returning id) "+
+			"insert into e_commerce.variants (item_id, sku) select id, coalesce(nullif($4, ''), 'ITEM-' || id) from item returning item_id, id",
+			item.Name, item.Description, item.Price, sku).Scan(&item.ID, &variantID)
+		if err != nil {
+			return err
+		}
+
+		if stock == 0 {
+			return nil
+		}
+
+		return tx.AdjustStock(ctx, &StockMovement{VariantID: variantID, Change: stock, Reason: StockInitial, ActorID: actor(actorID)})
+	})
+}
+
+func (r *ItemRepository) Get(ctx context.Context, id int) (*Item, error) {
+	item := &Item{ID: id}
+	err := r.db.QueryRow(ctx, "select name, description, price from e_commerce.items where id = $1", id).Scan(&item.Name, &item.Description, &item.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (r *ItemRepository) Exists(ctx context.Context, id int) (bool, error) {
+	exists := false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from e_commerce.items where id = $1)", id).Scan(&exists)
+	return exists, err
+}
+
+func (r *ItemRepository) Update(ctx context.Context, item *Item) error {
+	result, err := r.db.Exec(ctx, "update e_commerce.items set name = $1, description = $2, price = $3 where id = $4", item.Name, item.Description, item.Price, item.ID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// Delete returns the item as it was before it was deleted.
+func (r *ItemRepository) Delete(ctx context.Context, id int) (*Item, error) {
+	item := &Item{}
+	err := r.db.QueryRow(ctx, "delete from e_commerce.items where id = $1 returning id, name, description, price", id).
+		Scan(&item.ID, &item.Name, &item.Description, &item.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// inCategory matches the items which are in the category with the slug in
+// the given parameter or in any of the categories under it.
+func inCategory(parameter string) string {
+	return "i.id in (select ic.item_id from e_commerce.item_categories ic where ic.category_id in (" +
+		"with recursive tree as (select id from e_commerce.categories where slug = " + parameter + " " +
+		"union all select c.id from e_commerce.categories c join tree t on c.parent_id = t.id) select id from tree))"
+}
+
+func (r *ItemRepository) CategoryExists(ctx context.Context, slug string) (bool, error) {
+	exists := false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from e_commerce.categories where slug = $1)", slug).Scan(&exists)
+	return exists, err
+}
+
+// Search returns the items whose name matches. When category isn't empty only
+// the items in that category and the ones under it are searched.
+func (r *ItemRepository) Search(ctx context.Context, name, category string) ([]Item, error) {
+	query := "select id, name, description, price from e_commerce.items i where i.name ~ $1"
+	args := []any{name}
+	if category != "" {
+		query += " and " + inCategory("$2")
+		args = append(args, category)
+	}
+
+	rows, err := r.db.Query(ctx, query+" order by i.id", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanItems(rows)
+}
+
+func (r *ItemRepository) All(ctx context.Context, category string) ([]Item, error) {
+	query := "select id, name, description, price from e_commerce.items i"
+	args := []any{}
+	if category != "" {
+		query += " where " + inCategory("$1")
+		args = append(args, category)
+	}
+
+	rows, err := r.db.Query(ctx, query+" order by i.id", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanItems(rows)
+}
+
+func (r *ItemRepository) Random(ctx context.Context) (*Item, error) {
+	item := &Item{}
+	err := r.db.QueryRow(ctx, "select id, name, description, price from e_commerce.items order by random() limit 1").
+		Scan(&item.ID, &item.Name, &item.Description, &item.Price)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (r *ItemRepository) Count(ctx context.Context) (int, error) {
+	count := 0
+	err := r.db.QueryRow(ctx, "select count(*) from e_commerce.items").Scan(&count)
+	return count, err
+}
@@ -0,0 +1,257 @@
+package items
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// The reasons of the stock movements which aren't given by an admin.
+const (
+	StockInitial     = "initial"
+	StockSale        = "sale"
+	StockTransferred = "transfer"
+)
+
+const (
+	defaultMovementsPageSize = 50
+	maxMovementsPageSize     = 200
+)
+
+var ErrOutOfStock = errors.New("Error there isn't enough of this item in stock")
+
+type StockMovement struct {
+	ID            int       `json:"id"`
+	VariantID     int       `json:"variantID"`
+	WarehouseID   int       `json:"warehouseID"`
+	Change        int       `json:"change"`
+	StockAfter    int       `json:"stockAfter"`
+	Reason        string    `json:"reason"`
+	ReservationID *int      `json:"reservationID"`
+	TransferID    *int      `json:"transferID"`
+	ActorID       *int      `json:"actorID"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// actor is the id of the user who made a change, or nil when the id is 0
+// because the change wasn't made by a user.
+func actor(id int) *int {
+	if id == 0 {
+		return nil
+	}
+
+	return &id
+}
+
+// AdjustStock changes the stock of the variant in the warehouse of the
+// movement, or in the default warehouse when it has none, by its change and
+// records it in the ledger, filling in the rest of the movement. The stock in
+// the warehouse and the stock of the variant change together, so the latter
+// stays the sum of the former. It returns ErrOutOfStock if the stock would go
+// below zero, pgx.ErrNoRows if there is no such variant or
+// errNoSuchWarehouse if there is no such warehouse.
+func (r *ItemRepository) AdjustStock(ctx context.Context, movement *StockMovement) error {
+	return r.inTx(ctx, func(tx *ItemRepository) error {
+		if movement.WarehouseID == 0 {
+			id, err := tx.DefaultWarehouse(ctx)
+			if err != nil {
+				return err
+			}
+
+			movement.WarehouseID = id
+		}
+
+		if err := tx.stockExists(ctx, movement.VariantID, movement.WarehouseID); err != nil {
+			return err
+		}
+
+		_, err := tx.db.Exec(ctx, "insert into e_commerce.warehouse_stock (warehouse_id, variant_id) values ($1, $2) on conflict do nothing",
+			movement.WarehouseID, movement.VariantID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.db.QueryRow(ctx, "with located as (update e_commerce.warehouse_stock set stock = stock + $3 "+
+			"where variant_id = $1 and warehouse_id = $2 and stock + $3 >= 0 returning variant_id, warehouse_id, stock), "+
+			"total as (update e_commerce.variants v set stock = v.stock + $3 from located l where v.id = l.variant_id returning l.variant_id, l.warehouse_id, l.stock) "+
+			"insert into e_commerce.stock_movements (variant_id, warehouse_id, change, stock_after, reason, reservation_id, transfer_id, actor_id) "+
+			"select variant_id, warehouse_id, $3, stock, $4, $5, $6, $7 from total returning id, stock_after, created_at",
+			movement.VariantID, movement.WarehouseID, movement.Change, movement.Reason, movement.ReservationID, movement.TransferID, movement.ActorID).
+			Scan(&movement.ID, &movement.StockAfter, &movement.CreatedAt)
+		if err == pgx.ErrNoRows {
+			return ErrOutOfStock
+		}
+
+		return err
+	})
+}
+
+// stockExists returns pgx.ErrNoRows if there is no such variant or
+// errNoSuchWarehouse if one of the warehouses doesn't exist.
+func (r *ItemRepository) stockExists(ctx context.Context, variantID int, warehouseIDs ...int) error {
+	variantExists, warehousesExist := false, false
+	err := r.db.QueryRow(ctx, "select exists (select 1 from e_commerce.variants where id = $1), "+
+		"(select count(*) from e_commerce.warehouses where id = any($2)) = (select count(distinct id) from unnest($2::int[]) id)", variantID, warehouseIDs).
+		Scan(&variantExists, &warehousesExist)
+	if err != nil {
+		return err
+	}
+
+	if !variantExists {
+		return pgx.ErrNoRows
+	}
+
+	if !warehousesExist {
+		return errNoSuchWarehouse
+	}
+
+	return nil
+}
+
+// StockMovements returns a page of the ledger of the variant, newest first,
+// together with the number of all of its movements.
+func (r *ItemRepository) StockMovements(ctx context.Context, variantID, page, pageSize int) ([]StockMovement, int, error) {
+	total := 0
+	err := r.db.QueryRow(ctx, "select count(*) from e_commerce.stock_movements where variant_id = $1", variantID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.Query(ctx, "select id, variant_id, warehouse_id, change, stock_after, reason, reservation_id, transfer_id, actor_id, created_at from e_commerce.stock_movements "+
+		"where variant_id = $1 order by id desc limit $2 offset $3", variantID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	movements := []StockMovement{}
+	for rows.Next() {
+		movement := StockMovement{}
+		err = rows.Scan(&movement.ID, &movement.VariantID, &movement.WarehouseID, &movement.Change, &movement.StockAfter, &movement.Reason,
+			&movement.ReservationID, &movement.TransferID, &movement.ActorID, &movement.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		movements = append(movements, movement)
+	}
+
+	return movements, total, rows.Err()
+}
+
+// AdjustStock adds to or, with a negative change, takes from the stock of a
+// variant in a warehouse, or in the default one when none is given, for
+// deliveries, returns, damaged goods or recounts.
+func (h *ItemHandler) AdjustStock(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // variantID && change && reason && warehouseID?
+
+	variantID, ok := information["variantID"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the variant")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the variant"})
+		return
+	}
+
+	change, ok := information["change"].(float64)
+	if !ok || change == 0 || change != float64(int(change)) {
+		log.Println("Incorrectly provided change of the stock")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error the change of the stock has to be a whole number other than 0"})
+		return
+	}
+
+	reason, ok := information["reason"].(string)
+	if !ok || strings.TrimSpace(reason) == "" {
+		log.Println("Incorrectly provided reason")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error a reason for the change of the stock is required"})
+		return
+	}
+
+	warehouseID, _ := information["warehouseID"].(float64)
+
+	movement := StockMovement{VariantID: int(variantID), WarehouseID: int(warehouseID), Change: int(change), Reason: strings.TrimSpace(reason),
+		ActorID: actor(c.GetInt("id"))}
+	ctx := c.Request.Context()
+	err := h.items.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.AdjustStock(ctx, &movement); err != nil {
+			return err
+		}
+
+		before := gin.H{"warehouseID": movement.WarehouseID, "stock": movement.StockAfter - movement.Change}
+		return audit.Record(c, tx.db, "stock.adjust", "variant", movement.VariantID, before, movement)
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no variant with this id"})
+			return
+		}
+
+		if err == ErrOutOfStock {
+			c.JSON(http.StatusConflict, gin.H{"error": "Error the stock can't go below 0"})
+			return
+		}
+
+		if status := warehouseStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"movement": movement})
+}
+
+// GetStockMovements returns a page of the ledger of a variant.
+func (h *ItemHandler) GetStockMovements(c *gin.Context) {
+	variantID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the variant"})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided page"})
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", strconv.Itoa(defaultMovementsPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxMovementsPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided pageSize"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	variant, err := h.items.Variant(ctx, variantID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no variant with this id"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	movements, total, err := h.items.StockMovements(ctx, variantID, page, pageSize)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"variant": variant, "movements": movements, "total": total, "page": page, "pageSize": pageSize})
+}
@@ -0,0 +1,623 @@
+package items
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrVariantRequired  = errors.New("Error this item comes in several variants, choose one of them")
+	errSKUTaken         = errors.New("Error there is already a variant with this SKU")
+	errOptionsTaken     = errors.New("Error the item already has a variant with these options")
+	errOptionsDontMatch = errors.New("Error the options of the variant have to give a value to every option of the item")
+	errVariantsDontFit  = errors.New("Error some of the variants of the item don't fit these options, change or delete them first")
+	errLastVariant      = errors.New("Error an item needs at least one variant")
+)
+
+// ItemOption is an axis the variants of an item differ in, like size.
+type ItemOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+type Variant struct {
+	ID        int               `json:"id"`
+	ItemID    int               `json:"itemID"`
+	SKU       string            `json:"sku"`
+	Options   map[string]string `json:"options"`
+	Price     *float32          `json:"price"`
+	Stock     int               `json:"stock"`
+	Available int               `json:"available"`
+}
+
+// ItemVariant is an entry of a list, like the wishlist, which is for one
+// variant of an item.
+type ItemVariant struct {
+	Item    Item    `json:"item"`
+	Variant Variant `json:"variant"`
+}
+
+// VariantColumns are the columns of a variant, from a variants table aliased
+// v, in the order scanVariant and ScanItemVariants read them. The available
+// stock is what isn't held by a reservation.
+const VariantColumns = "v.id, v.item_id, v.sku, v.options, v.price, v.stock, v.stock - coalesce((select sum(r.quantity) from e_commerce.stock_reservations r " +
+	"where r.variant_id = v.id and r.status = 'active' and r.expires_at > now()), 0)"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVariant(row scanner, variant *Variant) error {
+	return row.Scan(&variant.ID, &variant.ItemID, &variant.SKU, &variant.Options, &variant.Price, &variant.Stock, &variant.Available)
+}
+
+// ScanItemVariants reads rows which have the columns of an item followed by
+// VariantColumns.
+func ScanItemVariants(rows pgx.Rows) ([]ItemVariant, error) {
+	defer rows.Close()
+
+	entries := []ItemVariant{}
+	for rows.Next() {
+		entry := ItemVariant{}
+		err := rows.Scan(&entry.Item.ID, &entry.Item.Name, &entry.Item.Description, &entry.Item.Price,
+			&entry.Variant.ID, &entry.Variant.ItemID, &entry.Variant.SKU, &entry.Variant.Options, &entry.Variant.Price, &entry.Variant.Stock, &entry.Variant.Available)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// OptionalVariantID reads the variantID of a request, which is nil when it
+// isn't given.
+func OptionalVariantID(information map[string]interface{}) *int {
+	variantID, ok := information["variantID"].(float64)
+	if !ok {
+		return nil
+	}
+
+	id := int(variantID)
+	return &id
+}
+
+func variantError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return err
+	}
+
+	if pgErr.ConstraintName == "variants_sku_key" {
+		return errSKUTaken
+	}
+
+	return errOptionsTaken
+}
+
+func (r *ItemRepository) Options(ctx context.Context, itemID int) ([]ItemOption, error) {
+	rows, err := r.db.Query(ctx, `select name, "values" from e_commerce.item_options where item_id = $1 order by position, id`, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	options := []ItemOption{}
+	for rows.Next() {
+		option := ItemOption{}
+		if err = rows.Scan(&option.Name, &option.Values); err != nil {
+			return nil, err
+		}
+
+		options = append(options, option)
+	}
+
+	return options, rows.Err()
+}
+
+// SetOptions replaces the option axes of the item. They are ordered the way
+// they are given.
+func (r *ItemRepository) SetOptions(ctx context.Context, itemID int, options []ItemOption) error {
+	return database.InTx(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "delete from e_commerce.item_options where item_id = $1", itemID)
+		if err != nil {
+			return err
+		}
+
+		for i, option := range options {
+			_, err = tx.Exec(ctx, `insert into e_commerce.item_options (item_id, name, "values", position) values ($1, $2, $3, $4)`,
+				itemID, option.Name, option.Values, i)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *ItemRepository) Variants(ctx context.Context, itemID int) ([]Variant, error) {
+	rows, err := r.db.Query(ctx, "select "+VariantColumns+" from e_commerce.variants v where v.item_id = $1 order by v.id", itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []Variant{}
+	for rows.Next() {
+		variant := Variant{}
+		if err = scanVariant(rows, &variant); err != nil {
+			return nil, err
+		}
+
+		variants = append(variants, variant)
+	}
+
+	return variants, rows.Err()
+}
+
+func (r *ItemRepository) Variant(ctx context.Context, id int) (*Variant, error) {
+	variant := &Variant{}
+	err := scanVariant(r.db.QueryRow(ctx, "select "+VariantColumns+" from e_commerce.variants v where v.id = $1", id), variant)
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
+
+// ResolveVariant finds the variant of the item which is meant by a request.
+// Without a variantID the item has to have just one variant, otherwise it
+// returns ErrVariantRequired. It returns pgx.ErrNoRows if there is no such
+// item or variant.
+func (r *ItemRepository) ResolveVariant(ctx context.Context, itemID int, variantID *int) (*Variant, error) {
+	if variantID != nil {
+		variant, err := r.Variant(ctx, *variantID)
+		if err != nil {
+			return nil, err
+		}
+
+		if variant.ItemID != itemID {
+			return nil, pgx.ErrNoRows
+		}
+
+		return variant, nil
+	}
+
+	variants, err := r.Variants(ctx, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(variants) {
+	case 0:
+		return nil, pgx.ErrNoRows
+	case 1:
+		return &variants[0], nil
+	}
+
+	return nil, ErrVariantRequired
+}
+
+// CreateVariant adds the variant with its initial stock in the default
+// warehouse, recorded in the ledger as done by actorID.
+func (r *ItemRepository) CreateVariant(ctx context.Context, variant *Variant, actorID int) error {
+	return r.inTx(ctx, func(tx *ItemRepository) error {
+		err := tx.db.QueryRow(ctx, "insert into e_commerce.variants (item_id, sku, options, price) values ($1, $2, $3, $4) returning id",
+			variant.ItemID, variant.SKU, variant.Options, variant.Price).Scan(&variant.ID)
+		if err != nil {
+			return variantError(err)
+		}
+
+		if variant.Stock == 0 {
+			return nil
+		}
+
+		return tx.AdjustStock(ctx, &StockMovement{VariantID: variant.ID, Change: variant.Stock, Reason: StockInitial, ActorID: actor(actorID)})
+	})
+}
+
+// UpdateVariant changes everything but the stock, which changes only through
+// AdjustStock so that it's in the ledger.
+func (r *ItemRepository) UpdateVariant(ctx context.Context, variant *Variant) error {
+	result, err := r.db.Exec(ctx, "update e_commerce.variants set sku = $1, options = $2, price = $3 where id = $4",
+		variant.SKU, variant.Options, variant.Price, variant.ID)
+	if err != nil {
+		return variantError(err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteVariant removes a variant, and with it the cart, wishlist and
+// comparison entries for it, unless it's the last one of its item.
+func (r *ItemRepository) DeleteVariant(ctx context.Context, id int) (*Variant, error) {
+	variant := &Variant{}
+	err := database.InTx(ctx, r.db, func(tx pgx.Tx) error {
+		err := scanVariant(tx.QueryRow(ctx, "select "+VariantColumns+" from e_commerce.variants v where v.id = $1", id), variant)
+		if err != nil {
+			return err
+		}
+
+		// Locking the item keeps two requests from deleting its last two
+		// variants at once.
+		_, err = tx.Exec(ctx, "select 1 from e_commerce.items where id = $1 for update", variant.ItemID)
+		if err != nil {
+			return err
+		}
+
+		others := 0
+		err = tx.QueryRow(ctx, "select count(*) from e_commerce.variants where item_id = $1 and id <> $2", variant.ItemID, id).Scan(&others)
+		if err != nil {
+			return err
+		}
+
+		if others == 0 {
+			return errLastVariant
+		}
+
+		_, err = tx.Exec(ctx, "delete from e_commerce.variants where id = $1", id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
+
+// fits reports whether the options of a variant give a value from the list of
+// every option of the item and nothing else.
+func fits(variantOptions map[string]string, options []ItemOption) bool {
+	if len(variantOptions) != len(options) {
+		return false
+	}
+
+	for _, option := range options {
+		value, ok := variantOptions[option.Name]
+		if !ok || !slices.Contains(option.Values, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func variantStatus(err error) int {
+	switch err {
+	case errSKUTaken, errOptionsTaken, errVariantsDontFit, errLastVariant:
+		return http.StatusConflict
+	case errOptionsDontMatch:
+		return http.StatusBadRequest
+	}
+
+	return 0
+}
+
+func parseVariantOptions(value interface{}) (map[string]string, bool) {
+	options := map[string]string{}
+	if value == nil {
+		return options, true
+	}
+
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	for name, value := range fields {
+		text, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+
+		options[name] = text
+	}
+
+	return options, true
+}
+
+// SetItemOptions replaces the option axes of an item, like
+// [{"name": "size", "values": ["S", "M", "L"]}]. Every variant of the item
+// has to fit the new options.
+func (h *ItemHandler) SetItemOptions(c *gin.Context) {
+	var information struct {
+		ItemID  *int         `json:"itemID"`
+		Options []ItemOption `json:"options"`
+	}
+	json.NewDecoder(c.Request.Body).Decode(&information) // itemID && options
+
+	if information.ItemID == nil {
+		log.Println("Incorrectly provided id of the item")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the item"})
+		return
+	}
+	itemID := *information.ItemID
+
+	names := map[string]bool{}
+	for i, option := range information.Options {
+		option.Name = strings.TrimSpace(option.Name)
+		if option.Name == "" || names[option.Name] || len(option.Values) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error every option needs a unique name and at least one value"})
+			return
+		}
+
+		names[option.Name] = true
+		information.Options[i] = option
+	}
+
+	ctx := c.Request.Context()
+	before, err := h.items.Options(ctx, itemID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	variants, err := h.items.Variants(ctx, itemID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if len(variants) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no item with this id"})
+		return
+	}
+
+	for _, variant := range variants {
+		if !fits(variant.Options, information.Options) {
+			c.JSON(http.StatusConflict, gin.H{"error": errVariantsDontFit.Error(), "variantID": variant.ID})
+			return
+		}
+	}
+
+	err = h.items.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.SetOptions(ctx, itemID, information.Options); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "item.options", "item", itemID, gin.H{"options": before}, gin.H{"options": information.Options})
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func (h *ItemHandler) CreateVariant(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // itemID && sku && (options || price || stock)
+
+	itemID, ok := information["itemID"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the item")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the item"})
+		return
+	}
+
+	sku, ok := information["sku"].(string)
+	if !ok || strings.TrimSpace(sku) == "" {
+		log.Println("Incorrectly provided SKU")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided SKU"})
+		return
+	}
+
+	options, ok := parseVariantOptions(information["options"])
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error the options of the variant have to be names with text values"})
+		return
+	}
+
+	variant := Variant{ItemID: int(itemID), SKU: strings.TrimSpace(sku), Options: options}
+	if price, ok := information["price"].(float64); ok {
+		override := float32(price)
+		variant.Price = &override
+	}
+
+	if stock, ok := information["stock"].(float64); ok {
+		if stock < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error the stock can't be negative"})
+			return
+		}
+
+		variant.Stock = int(stock)
+	}
+
+	ctx := c.Request.Context()
+	exists, err := h.items.Exists(ctx, variant.ItemID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no item with this id"})
+		return
+	}
+
+	itemOptions, err := h.items.Options(ctx, variant.ItemID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if !fits(variant.Options, itemOptions) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errOptionsDontMatch.Error()})
+		return
+	}
+
+	err = h.items.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.CreateVariant(ctx, &variant, c.GetInt("id")); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "variant.create", "variant", variant.ID, nil, variant)
+	})
+	if err != nil {
+		if status := variantStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information about the variant in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"variant": variant})
+}
+
+// UpdateVariant changes the SKU, the options or the price of a variant. A
+// price of null removes the override, so the variant costs as much as its
+// item. The stock is changed with AdjustStock.
+func (h *ItemHandler) UpdateVariant(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id && (sku || options || price)
+
+	id, ok := information["id"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the variant")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the variant"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	before, err := h.items.Variant(ctx, int(id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no variant with this id"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	after := *before
+	if sku, ok := information["sku"].(string); ok && strings.TrimSpace(sku) != "" {
+		after.SKU = strings.TrimSpace(sku)
+	}
+
+	if value, ok := information["options"]; ok {
+		options, ok := parseVariantOptions(value)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error the options of the variant have to be names with text values"})
+			return
+		}
+
+		itemOptions, err := h.items.Options(ctx, after.ItemID)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+			return
+		}
+
+		if !fits(options, itemOptions) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errOptionsDontMatch.Error()})
+			return
+		}
+
+		after.Options = options
+	}
+
+	if value, ok := information["price"]; ok {
+		switch price := value.(type) {
+		case nil:
+			after.Price = nil
+		case float64:
+			override := float32(price)
+			after.Price = &override
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided price of the variant"})
+			return
+		}
+	}
+
+	err = h.items.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.UpdateVariant(ctx, &after); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "variant.update", "variant", after.ID, before, after)
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no variant with this id"})
+			return
+		}
+
+		if status := variantStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"variant": after})
+}
+
+func (h *ItemHandler) DeleteVariant(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id
+
+	id, ok := information["id"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the variant")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the variant"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := h.items.inTx(ctx, func(tx *ItemRepository) error {
+		variant, err := tx.DeleteVariant(ctx, int(id))
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "variant.delete", "variant", variant.ID, variant, nil)
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no variant with this id"})
+			return
+		}
+
+		if status := variantStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to delete the variant from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
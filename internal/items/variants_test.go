@@ -0,0 +1,33 @@
+package items
+
+import "testing"
+
+func TestFits(t *testing.T) {
+	options := []ItemOption{
+		{Name: "size", Values: []string{"S", "M", "L"}},
+		{Name: "color", Values: []string{"red", "blue"}},
+	}
+
+	tests := []struct {
+		name           string
+		variantOptions map[string]string
+		options        []ItemOption
+		fits           bool
+	}{
+		{"every option", map[string]string{"size": "M", "color": "red"}, options, true},
+		{"missing option", map[string]string{"size": "M"}, options, false},
+		{"unknown value", map[string]string{"size": "XL", "color": "red"}, options, false},
+		{"unknown option", map[string]string{"size": "M", "material": "red"}, options, false},
+		{"extra option", map[string]string{"size": "M", "color": "red", "material": "wool"}, options, false},
+		{"item without options", map[string]string{}, nil, true},
+		{"options on an item without them", map[string]string{"size": "M"}, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if fits := fits(test.variantOptions, test.options); fits != test.fits {
+				t.Errorf("fits() = %v, want %v", fits, test.fits)
+			}
+		})
+	}
+}
@@ -0,0 +1,696 @@
+package items
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Phantomvv1/E-commerce/internal/audit"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	errNoSuchWarehouse    = errors.New("Error there is no warehouse with this id")
+	errWarehouseCodeTaken = errors.New("Error there is already a warehouse with this code")
+	errWarehouseInUse     = errors.New("Error this warehouse has held stock, so it can't be deleted")
+	errSameWarehouse      = errors.New("Error the stock has to be transferred to a different warehouse")
+)
+
+type Warehouse struct {
+	ID       int    `json:"id"`
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Priority int    `json:"priority"`
+}
+
+// WarehouseStock is how much of a variant there is in a warehouse and how
+// much of that isn't held by a reservation.
+type WarehouseStock struct {
+	WarehouseID int    `json:"warehouseID"`
+	Code        string `json:"code"`
+	VariantID   int    `json:"variantID"`
+	Stock       int    `json:"stock"`
+	Available   int    `json:"available"`
+}
+
+// StockRequest is how much of a variant has to be allocated.
+type StockRequest struct {
+	VariantID int `json:"variantID"`
+	Quantity  int `json:"quantity"`
+}
+
+// Allocation is how much of a variant is taken from a warehouse.
+type Allocation struct {
+	WarehouseID int `json:"warehouseID"`
+	VariantID   int `json:"variantID"`
+	Quantity    int `json:"quantity"`
+}
+
+type StockTransfer struct {
+	ID              int       `json:"id"`
+	VariantID       int       `json:"variantID"`
+	FromWarehouseID int       `json:"fromWarehouseID"`
+	ToWarehouseID   int       `json:"toWarehouseID"`
+	Quantity        int       `json:"quantity"`
+	ActorID         *int      `json:"actorID"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// stockLevels are the columns of WarehouseStock for the variants in $1, in
+// the order of the priority of their warehouses.
+const stockLevels = "select ws.warehouse_id, w.code, ws.variant_id, ws.stock, ws.stock - coalesce((select sum(r.quantity) from e_commerce.stock_reservations r " +
+	"where r.warehouse_id = ws.warehouse_id and r.variant_id = ws.variant_id and r.status = 'active' and r.expires_at > now()), 0) " +
+	"from e_commerce.warehouse_stock ws join e_commerce.warehouses w on w.id = ws.warehouse_id where ws.variant_id = any($1) " +
+	"order by w.priority, w.id, ws.variant_id"
+
+func warehouseError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case "23505":
+		return errWarehouseCodeTaken
+	case "23503":
+		return errWarehouseInUse
+	}
+
+	return err
+}
+
+func warehouseStatus(err error) int {
+	switch err {
+	case errNoSuchWarehouse:
+		return http.StatusNotFound
+	case errWarehouseCodeTaken, errWarehouseInUse:
+		return http.StatusConflict
+	case errSameWarehouse:
+		return http.StatusBadRequest
+	}
+
+	return 0
+}
+
+func (r *ItemRepository) Warehouses(ctx context.Context) ([]Warehouse, error) {
+	rows, err := r.db.Query(ctx, "select id, code, name, address, priority from e_commerce.warehouses order by priority, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	warehouses := []Warehouse{}
+	for rows.Next() {
+		warehouse := Warehouse{}
+		if err = rows.Scan(&warehouse.ID, &warehouse.Code, &warehouse.Name, &warehouse.Address, &warehouse.Priority); err != nil {
+			return nil, err
+		}
+
+		warehouses = append(warehouses, warehouse)
+	}
+
+	return warehouses, rows.Err()
+}
+
+func (r *ItemRepository) Warehouse(ctx context.Context, id int) (*Warehouse, error) {
+	warehouse := &Warehouse{}
+	err := r.db.QueryRow(ctx, "select id, code, name, address, priority from e_commerce.warehouses where id = $1", id).
+		Scan(&warehouse.ID, &warehouse.Code, &warehouse.Name, &warehouse.Address, &warehouse.Priority)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, errNoSuchWarehouse
+		}
+
+		return nil, err
+	}
+
+	return warehouse, nil
+}
+
+// DefaultWarehouse returns the id of the warehouse with the lowest priority,
+// which stock goes into when no warehouse is given.
+func (r *ItemRepository) DefaultWarehouse(ctx context.Context) (int, error) {
+	id := 0
+	err := r.db.QueryRow(ctx, "select id from e_commerce.warehouses order by priority, id limit 1").Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, errNoSuchWarehouse
+	}
+
+	return id, err
+}
+
+func (r *ItemRepository) CreateWarehouse(ctx context.Context, warehouse *Warehouse) error {
+	err := r.db.QueryRow(ctx, "insert into e_commerce.warehouses (code, name, address, priority) values ($1, $2, $3, $4) returning id",
+		warehouse.Code, warehouse.Name, warehouse.Address, warehouse.Priority).Scan(&warehouse.ID)
+	return warehouseError(err)
+}
+
+func (r *ItemRepository) UpdateWarehouse(ctx context.Context, warehouse *Warehouse) error {
+	result, err := r.db.Exec(ctx, "update e_commerce.warehouses set code = $1, name = $2, address = $3, priority = $4 where id = $5",
+		warehouse.Code, warehouse.Name, warehouse.Address, warehouse.Priority, warehouse.ID)
+	if err != nil {
+		return warehouseError(err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return errNoSuchWarehouse
+	}
+
+	return nil
+}
+
+// DeleteWarehouse deletes a warehouse which has never held any stock, since
+// the ledger still refers to the others. It returns the warehouse as it was
+// before it was deleted.
+func (r *ItemRepository) DeleteWarehouse(ctx context.Context, id int) (*Warehouse, error) {
+	warehouse := &Warehouse{}
+	err := r.inTx(ctx, func(tx *ItemRepository) error {
+		_, err := tx.db.Exec(ctx, "delete from e_commerce.warehouse_stock where warehouse_id = $1 and stock = 0", id)
+		if err != nil {
+			return err
+		}
+
+		err = tx.db.QueryRow(ctx, "delete from e_commerce.warehouses where id = $1 returning id, code, name, address, priority", id).
+			Scan(&warehouse.ID, &warehouse.Code, &warehouse.Name, &warehouse.Address, &warehouse.Priority)
+		if err == pgx.ErrNoRows {
+			return errNoSuchWarehouse
+		}
+
+		return warehouseError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return warehouse, nil
+}
+
+func scanStockLevels(rows pgx.Rows) ([]WarehouseStock, error) {
+	defer rows.Close()
+
+	levels := []WarehouseStock{}
+	for rows.Next() {
+		level := WarehouseStock{}
+		if err := rows.Scan(&level.WarehouseID, &level.Code, &level.VariantID, &level.Stock, &level.Available); err != nil {
+			return nil, err
+		}
+
+		levels = append(levels, level)
+	}
+
+	return levels, rows.Err()
+}
+
+func (r *ItemRepository) StockLevels(ctx context.Context, variantID int) ([]WarehouseStock, error) {
+	rows, err := r.db.Query(ctx, stockLevels, []int{variantID})
+	if err != nil {
+		return nil, err
+	}
+
+	return scanStockLevels(rows)
+}
+
+// lockStock locks the stock of the variants in every warehouse, in the same
+// order as stockLevels so that two transactions can't wait on each other. The
+// locks have to be taken in a statement of their own: a statement only sees
+// what was committed when it started, so one which waited for the locks would
+// still miss the reservations made by the transaction which held them.
+func (r *ItemRepository) lockStock(ctx context.Context, variantIDs []int) error {
+	_, err := r.db.Exec(ctx, "select 1 from e_commerce.warehouse_stock ws join e_commerce.warehouses w on w.id = ws.warehouse_id "+
+		"where ws.variant_id = any($1) order by w.priority, w.id, ws.variant_id for update of ws", variantIDs)
+	return err
+}
+
+// Allocate picks the warehouses the requested stock is taken from. It locks
+// the stock of the variants in every warehouse, so run in a transaction it
+// keeps the allocation valid until the transaction ends. It returns
+// ErrOutOfStock when there isn't enough of one of the variants.
+func (r *ItemRepository) Allocate(ctx context.Context, requests []StockRequest) ([]Allocation, error) {
+	variantIDs := []int{}
+	for _, request := range requests {
+		variantIDs = append(variantIDs, request.VariantID)
+	}
+
+	levels := []WarehouseStock{}
+	err := r.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.lockStock(ctx, variantIDs); err != nil {
+			return err
+		}
+
+		rows, err := tx.db.Query(ctx, stockLevels, variantIDs)
+		if err != nil {
+			return err
+		}
+
+		levels, err = scanStockLevels(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return allocate(requests, levels)
+}
+
+// LockedStockLevels is StockLevels which locks the stock of the variant in
+// every warehouse first, so run in a transaction the levels stay valid until
+// the transaction ends.
+func (r *ItemRepository) LockedStockLevels(ctx context.Context, variantID int) ([]WarehouseStock, error) {
+	levels := []WarehouseStock{}
+	err := r.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.lockStock(ctx, []int{variantID}); err != nil {
+			return err
+		}
+
+		var err error
+		levels, err = tx.StockLevels(ctx, variantID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return levels, nil
+}
+
+// allocate prefers a single warehouse which has all of the requested stock,
+// so the order ships in one parcel, and of those the one which comes first in
+// levels. When there is no such warehouse every variant is taken from the
+// warehouses in the order of levels until there is enough of it. levels have
+// to be in the order of the priority of their warehouses.
+func allocate(requests []StockRequest, levels []WarehouseStock) ([]Allocation, error) {
+	needed := map[int]int{}
+	variantIDs := []int{}
+	for _, request := range requests {
+		if _, ok := needed[request.VariantID]; !ok {
+			variantIDs = append(variantIDs, request.VariantID)
+		}
+
+		needed[request.VariantID] += request.Quantity
+	}
+
+	warehouseIDs := []int{}
+	available := map[int]map[int]int{}
+	for _, level := range levels {
+		if available[level.WarehouseID] == nil {
+			warehouseIDs = append(warehouseIDs, level.WarehouseID)
+			available[level.WarehouseID] = map[int]int{}
+		}
+
+		available[level.WarehouseID][level.VariantID] = level.Available
+	}
+
+	for _, warehouseID := range warehouseIDs {
+		fits := true
+		for _, variantID := range variantIDs {
+			if available[warehouseID][variantID] < needed[variantID] {
+				fits = false
+				break
+			}
+		}
+
+		if !fits {
+			continue
+		}
+
+		allocations := []Allocation{}
+		for _, variantID := range variantIDs {
+			allocations = append(allocations, Allocation{WarehouseID: warehouseID, VariantID: variantID, Quantity: needed[variantID]})
+		}
+
+		return allocations, nil
+	}
+
+	allocations := []Allocation{}
+	for _, variantID := range variantIDs {
+		remaining := needed[variantID]
+		for _, warehouseID := range warehouseIDs {
+			taken := min(remaining, available[warehouseID][variantID])
+			if taken <= 0 {
+				continue
+			}
+
+			allocations = append(allocations, Allocation{WarehouseID: warehouseID, VariantID: variantID, Quantity: taken})
+			remaining -= taken
+			if remaining == 0 {
+				break
+			}
+		}
+
+		if remaining > 0 {
+			return nil, ErrOutOfStock
+		}
+	}
+
+	return allocations, nil
+}
+
+// Transfer moves stock which isn't reserved from one warehouse to another,
+// recording both sides in the ledger.
+func (r *ItemRepository) Transfer(ctx context.Context, transfer *StockTransfer) error {
+	if transfer.FromWarehouseID == transfer.ToWarehouseID {
+		return errSameWarehouse
+	}
+
+	return r.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.stockExists(ctx, transfer.VariantID, transfer.FromWarehouseID, transfer.ToWarehouseID); err != nil {
+			return err
+		}
+
+		if err := tx.lockStock(ctx, []int{transfer.VariantID}); err != nil {
+			return err
+		}
+
+		levels, err := tx.StockLevels(ctx, transfer.VariantID)
+		if err != nil {
+			return err
+		}
+
+		available := 0
+		for _, level := range levels {
+			if level.WarehouseID == transfer.FromWarehouseID {
+				available = level.Available
+			}
+		}
+
+		if available < transfer.Quantity {
+			return ErrOutOfStock
+		}
+
+		err = tx.db.QueryRow(ctx, "insert into e_commerce.stock_transfers (variant_id, from_warehouse_id, to_warehouse_id, quantity, actor_id) "+
+			"values ($1, $2, $3, $4, $5) returning id, created_at", transfer.VariantID, transfer.FromWarehouseID, transfer.ToWarehouseID, transfer.Quantity,
+			transfer.ActorID).Scan(&transfer.ID, &transfer.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		out := StockMovement{VariantID: transfer.VariantID, WarehouseID: transfer.FromWarehouseID, Change: -transfer.Quantity, Reason: StockTransferred,
+			TransferID: &transfer.ID, ActorID: transfer.ActorID}
+		if err = tx.AdjustStock(ctx, &out); err != nil {
+			return err
+		}
+
+		in := StockMovement{VariantID: transfer.VariantID, WarehouseID: transfer.ToWarehouseID, Change: transfer.Quantity, Reason: StockTransferred,
+			TransferID: &transfer.ID, ActorID: transfer.ActorID}
+		return tx.AdjustStock(ctx, &in)
+	})
+}
+
+func (h *ItemHandler) GetWarehouses(c *gin.Context) {
+	warehouses, err := h.items.Warehouses(c.Request.Context())
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"warehouses": warehouses})
+}
+
+// CreateWarehouse adds a warehouse. The warehouses with a lower priority are
+// the first ones checkouts are fulfilled from and the one with the lowest is
+// where stock goes when no warehouse is given.
+func (h *ItemHandler) CreateWarehouse(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // code && name && address? && priority?
+
+	code, ok := information["code"].(string)
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !ok || code == "" {
+		log.Println("Incorrectly provided code of the warehouse")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided code of the warehouse"})
+		return
+	}
+
+	name, ok := information["name"].(string)
+	if !ok || strings.TrimSpace(name) == "" {
+		log.Println("Incorrectly provided name of the warehouse")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided name of the warehouse"})
+		return
+	}
+
+	address, _ := information["address"].(string)
+	priority, _ := information["priority"].(float64)
+
+	warehouse := Warehouse{Code: code, Name: strings.TrimSpace(name), Address: strings.TrimSpace(address), Priority: int(priority)}
+	ctx := c.Request.Context()
+	err := h.items.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.CreateWarehouse(ctx, &warehouse); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "warehouse.create", "warehouse", warehouse.ID, nil, warehouse)
+	})
+	if err != nil {
+		if status := warehouseStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"warehouse": warehouse})
+}
+
+func (h *ItemHandler) UpdateWarehouse(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id && (code || name || address || priority)
+
+	id, ok := information["id"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the warehouse")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the warehouse"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	before, err := h.items.Warehouse(ctx, int(id))
+	if err != nil {
+		if err == errNoSuchWarehouse {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	after := *before
+	updated := false
+	if code, ok := information["code"].(string); ok && strings.TrimSpace(code) != "" {
+		after.Code = strings.ToUpper(strings.TrimSpace(code))
+		updated = true
+	}
+
+	if name, ok := information["name"].(string); ok && strings.TrimSpace(name) != "" {
+		after.Name = strings.TrimSpace(name)
+		updated = true
+	}
+
+	if address, ok := information["address"].(string); ok {
+		after.Address = strings.TrimSpace(address)
+		updated = true
+	}
+
+	if priority, ok := information["priority"].(float64); ok {
+		after.Priority = int(priority)
+		updated = true
+	}
+
+	if !updated {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error not enough information to update the warehouse with"})
+		return
+	}
+
+	err = h.items.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.UpdateWarehouse(ctx, &after); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "warehouse.update", "warehouse", after.ID, before, after)
+	})
+	if err != nil {
+		if status := warehouseStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"warehouse": after})
+}
+
+func (h *ItemHandler) DeleteWarehouse(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // id
+
+	id, ok := information["id"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the warehouse")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the warehouse"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := h.items.inTx(ctx, func(tx *ItemRepository) error {
+		warehouse, err := tx.DeleteWarehouse(ctx, int(id))
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "warehouse.delete", "warehouse", warehouse.ID, warehouse, nil)
+	})
+	if err != nil {
+		if status := warehouseStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to delete the warehouse from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+// GetVariantStock returns how much of a variant there is in every warehouse.
+func (h *ItemHandler) GetVariantStock(c *gin.Context) {
+	variantID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the variant"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	variant, err := h.items.Variant(ctx, variantID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no variant with this id"})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	levels, err := h.items.StockLevels(ctx, variantID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"variant": variant, "warehouses": levels})
+}
+
+func (h *ItemHandler) TransferStock(c *gin.Context) {
+	var information map[string]interface{}
+	json.NewDecoder(c.Request.Body).Decode(&information) // variantID && fromWarehouseID && toWarehouseID && quantity
+
+	variantID, ok := information["variantID"].(float64)
+	if !ok {
+		log.Println("Incorrectly provided id of the variant")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the variant"})
+		return
+	}
+
+	from, okFrom := information["fromWarehouseID"].(float64)
+	to, okTo := information["toWarehouseID"].(float64)
+	if !okFrom || !okTo {
+		log.Println("Incorrectly provided warehouses")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided ids of the warehouses"})
+		return
+	}
+
+	quantity, ok := information["quantity"].(float64)
+	if !ok || quantity < 1 || quantity != float64(int(quantity)) {
+		log.Println("Incorrectly provided quantity")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error the quantity has to be a whole number of at least 1"})
+		return
+	}
+
+	transfer := StockTransfer{VariantID: int(variantID), FromWarehouseID: int(from), ToWarehouseID: int(to), Quantity: int(quantity),
+		ActorID: actor(c.GetInt("id"))}
+	ctx := c.Request.Context()
+	err := h.items.inTx(ctx, func(tx *ItemRepository) error {
+		if err := tx.Transfer(ctx, &transfer); err != nil {
+			return err
+		}
+
+		return audit.Record(c, tx.db, "stock.transfer", "variant", transfer.VariantID, nil, transfer)
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no variant with this id"})
+			return
+		}
+
+		if err == ErrOutOfStock {
+			c.JSON(http.StatusConflict, gin.H{"error": "Error there isn't enough unreserved stock of this variant in the warehouse"})
+			return
+		}
+
+		if status := warehouseStatus(err); status != 0 {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to update the information in the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transfer": transfer})
+}
+
+// AllocateStock shows which warehouses the given variants would be taken from
+// at checkout right now, without reserving anything.
+func (h *ItemHandler) AllocateStock(c *gin.Context) {
+	var information struct {
+		Items []StockRequest `json:"items"`
+	}
+	json.NewDecoder(c.Request.Body).Decode(&information) // items
+
+	if len(information.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error no items to allocate"})
+		return
+	}
+
+	for _, request := range information.Items {
+		if request.Quantity < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error the quantity of every item has to be at least 1"})
+			return
+		}
+	}
+
+	allocations, err := h.items.Allocate(c.Request.Context(), information.Items)
+	if err != nil {
+		if err == ErrOutOfStock {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"allocations": allocations})
+}
@@ -0,0 +1,106 @@
+package items
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	// Warehouse 1 comes first, then 2 and 3.
+	levels := []WarehouseStock{
+		{WarehouseID: 1, VariantID: 10, Available: 5},
+		{WarehouseID: 1, VariantID: 20, Available: 0},
+		{WarehouseID: 2, VariantID: 10, Available: 3},
+		{WarehouseID: 2, VariantID: 20, Available: 4},
+		{WarehouseID: 3, VariantID: 10, Available: 10},
+		{WarehouseID: 3, VariantID: 20, Available: 10},
+	}
+
+	tests := []struct {
+		name        string
+		requests    []StockRequest
+		levels      []WarehouseStock
+		allocations []Allocation
+		err         error
+	}{
+		{
+			"first warehouse with enough",
+			[]StockRequest{{VariantID: 10, Quantity: 5}},
+			levels,
+			[]Allocation{{WarehouseID: 1, VariantID: 10, Quantity: 5}},
+			nil,
+		},
+		{
+			"single warehouse with everything",
+			[]StockRequest{{VariantID: 10, Quantity: 2}, {VariantID: 20, Quantity: 2}},
+			levels,
+			[]Allocation{{WarehouseID: 2, VariantID: 10, Quantity: 2}, {WarehouseID: 2, VariantID: 20, Quantity: 2}},
+			nil,
+		},
+		{
+			"same variant requested twice",
+			[]StockRequest{{VariantID: 10, Quantity: 3}, {VariantID: 10, Quantity: 3}},
+			levels,
+			[]Allocation{{WarehouseID: 3, VariantID: 10, Quantity: 6}},
+			nil,
+		},
+		{
+			"split between warehouses",
+			[]StockRequest{{VariantID: 10, Quantity: 16}},
+			levels,
+			[]Allocation{
+				{WarehouseID: 1, VariantID: 10, Quantity: 5},
+				{WarehouseID: 2, VariantID: 10, Quantity: 3},
+				{WarehouseID: 3, VariantID: 10, Quantity: 8},
+			},
+			nil,
+		},
+		{
+			"split skips empty warehouses",
+			[]StockRequest{{VariantID: 10, Quantity: 11}, {VariantID: 20, Quantity: 12}},
+			levels,
+			[]Allocation{
+				{WarehouseID: 1, VariantID: 10, Quantity: 5},
+				{WarehouseID: 2, VariantID: 10, Quantity: 3},
+				{WarehouseID: 3, VariantID: 10, Quantity: 3},
+				{WarehouseID: 2, VariantID: 20, Quantity: 4},
+				{WarehouseID: 3, VariantID: 20, Quantity: 8},
+			},
+			nil,
+		},
+		{
+			"not enough stock",
+			[]StockRequest{{VariantID: 10, Quantity: 19}},
+			levels,
+			nil,
+			ErrOutOfStock,
+		},
+		{
+			"variant without stock",
+			[]StockRequest{{VariantID: 30, Quantity: 1}},
+			levels,
+			nil,
+			ErrOutOfStock,
+		},
+		{
+			"no warehouses",
+			[]StockRequest{{VariantID: 10, Quantity: 1}},
+			nil,
+			nil,
+			ErrOutOfStock,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allocations, err := allocate(test.requests, test.levels)
+			if err != test.err {
+				t.Fatalf("allocate() error = %v, want %v", err, test.err)
+			}
+
+			if !reflect.DeepEqual(allocations, test.allocations) {
+				t.Errorf("allocate() = %v, want %v", allocations, test.allocations)
+			}
+		})
+	}
+}
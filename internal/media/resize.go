@@ -0,0 +1,82 @@
+package media
+
+import (
+	"image"
+	"image/draw"
+)
+
+// weight is how much of a source pixel goes into a pixel of the result.
+type weight struct {
+	index  int
+	amount float64
+}
+
+// weights spreads size source pixels over scaled ones. Every scaled pixel
+// covers size/scaled source pixels, the ones at its edges only partly.
+func weights(size, scaled int) [][]weight {
+	ratio := float64(size) / float64(scaled)
+	all := make([][]weight, scaled)
+	for i := range all {
+		start, end := float64(i)*ratio, float64(i+1)*ratio
+		for j := int(start); j < size && float64(j) < end; j++ {
+			covered := min(end, float64(j+1)) - max(start, float64(j))
+			if covered > 0 {
+				all[i] = append(all[i], weight{index: j, amount: covered / ratio})
+			}
+		}
+	}
+
+	return all
+}
+
+// Fit scales img down, keeping its proportions, so that it fits in a square
+// with sides of size. Every pixel of the result is the average of the source
+// pixels under it, which unlike picking the nearest pixel doesn't alias. An
+// image which already fits is only copied.
+func Fit(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	source := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(source, source.Bounds(), img, bounds.Min, draw.Src)
+
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return source
+	}
+
+	scaledWidth, scaledHeight := size, size
+	if width > height {
+		scaledHeight = max(1, height*size/width)
+	} else {
+		scaledWidth = max(1, width*size/height)
+	}
+
+	columns, rows := weights(width, scaledWidth), weights(height, scaledHeight)
+	scaled := image.NewRGBA(image.Rect(0, 0, scaledWidth, scaledHeight))
+	// The pixels are premultiplied by their alpha, so averaging them doesn't
+	// bleed the color of transparent pixels into the result.
+	for y, rowWeights := range rows {
+		for x, columnWeights := range columns {
+			var r, g, b, a float64
+			for _, row := range rowWeights {
+				offset := row.index * source.Stride
+				for _, column := range columnWeights {
+					amount := row.amount * column.amount
+					pixel := source.Pix[offset+column.index*4 : offset+column.index*4+4]
+					r += float64(pixel[0]) * amount
+					g += float64(pixel[1]) * amount
+					b += float64(pixel[2]) * amount
+					a += float64(pixel[3]) * amount
+				}
+			}
+
+			pixel := scaled.Pix[y*scaled.Stride+x*4 : y*scaled.Stride+x*4+4]
+			pixel[0], pixel[1], pixel[2], pixel[3] = channel(r), channel(g), channel(b), channel(a)
+		}
+	}
+
+	return scaled
+}
+
+func channel(value float64) uint8 {
+	return uint8(min(255, max(0, value+0.5)))
+}
@@ -0,0 +1,83 @@
+package media
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func filled(bounds image.Rectangle, c color.Color) *image.RGBA {
+	img := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	return img
+}
+
+func TestFitSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		bounds image.Rectangle
+		width  int
+		height int
+	}{
+		{"fits already", image.Rect(0, 0, 80, 60), 80, 60},
+		{"offset bounds", image.Rect(10, 20, 90, 80), 80, 60},
+		{"landscape", image.Rect(0, 0, 400, 200), 100, 50},
+		{"portrait", image.Rect(0, 0, 200, 400), 50, 100},
+		{"square", image.Rect(0, 0, 300, 300), 100, 100},
+		{"thin line", image.Rect(0, 0, 1000, 1), 100, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fitted := Fit(filled(test.bounds, color.White), 100)
+			if fitted.Bounds() != image.Rect(0, 0, test.width, test.height) {
+				t.Errorf("Fit() bounds = %v, want %dx%d from the origin", fitted.Bounds(), test.width, test.height)
+			}
+		})
+	}
+}
+
+func TestFitAverages(t *testing.T) {
+	// Black and white columns become gray, and a color stays the same.
+	stripes := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			stripes.Set(x, y, color.Gray{Y: uint8(255 * (x % 2))})
+		}
+	}
+
+	// Transparent pixels don't darken the opaque ones next to them.
+	halfTransparent := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	halfTransparent.Set(0, 0, color.RGBA{R: 255, A: 255})
+	halfTransparent.Set(0, 1, color.RGBA{R: 255, A: 255})
+
+	tests := []struct {
+		name  string
+		img   image.Image
+		size  int
+		pixel color.RGBA
+	}{
+		{"stripes", stripes, 2, color.RGBA{R: 128, G: 128, B: 128, A: 255}},
+		{"one color", filled(image.Rect(0, 0, 30, 30), color.RGBA{R: 10, G: 200, B: 30, A: 255}), 7, color.RGBA{R: 10, G: 200, B: 30, A: 255}},
+		{"transparency", halfTransparent, 1, color.RGBA{R: 128, A: 128}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fitted := Fit(test.img, test.size)
+			bounds := fitted.Bounds()
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					if pixel := fitted.RGBAAt(x, y); pixel != test.pixel {
+						t.Fatalf("pixel %d,%d = %v, want %v", x, y, pixel, test.pixel)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps the files in a bucket of S3 or of a service compatible with
+// it, like MinIO or R2. The requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// PublicURL is where the files of the bucket are served from, the bucket
+	// on the endpoint unless a CDN or a custom domain is in front of it.
+	PublicURL string
+	client    *http.Client
+}
+
+// NewS3Storage reads S3_BUCKET, S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY and
+// optionally S3_REGION, S3_ENDPOINT and S3_PUBLIC_URL. The objects are
+// addressed by path, which every compatible service supports.
+func NewS3Storage() (*S3Storage, error) {
+	storage := &S3Storage{
+		Endpoint:  strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		Region:    os.Getenv("S3_REGION"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		PublicURL: strings.TrimSuffix(os.Getenv("S3_PUBLIC_URL"), "/"),
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+
+	if storage.Bucket == "" || storage.AccessKey == "" || storage.SecretKey == "" {
+		return nil, errors.New("Error S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required to store the media in S3")
+	}
+
+	if storage.Region == "" {
+		storage.Region = "us-east-1"
+	}
+
+	if storage.Endpoint == "" {
+		storage.Endpoint = "https://s3." + storage.Region + ".amazonaws.com"
+	}
+
+	if storage.PublicURL == "" {
+		storage.PublicURL = storage.Endpoint + "/" + storage.Bucket
+	}
+
+	return storage, nil
+}
+
+// escapeKey escapes every character of the key apart from the unreserved
+// ones and the slashes, the way Signature Version 4 expects the path.
+func escapeKey(key string) string {
+	escaped := strings.Builder{}
+	for _, c := range []byte(key) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+
+	return escaped.String()
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	if err := s.do(ctx, http.MethodPut, key, data, contentType); err != nil {
+		return "", err
+	}
+
+	return s.PublicURL + "/" + escapeKey(key), nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	return s.do(ctx, http.MethodDelete, key, nil, "")
+}
+
+func (s *S3Storage) do(ctx context.Context, method, key string, data []byte, contentType string) error {
+	path := "/" + escapeKey(s.Bucket) + "/" + escapeKey(key)
+	request, err := http.NewRequestWithContext(ctx, method, s.Endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	s.sign(request, path, data, time.Now().UTC())
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("S3 responded to %s %s with %s: %s", method, key, response.Status, body)
+	}
+
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign adds the headers of Signature Version 4 to a request without a query.
+// Every header the request has by then is signed.
+func (s *S3Storage) sign(request *http.Request, path string, data []byte, now time.Time) {
+	payloadHash := sha256.Sum256(data)
+	date := now.Format("20060102")
+	timestamp := now.Format("20060102T150405Z")
+
+	request.Header.Set("X-Amz-Date", timestamp)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		path,
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
@@ -0,0 +1,99 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage keeps the uploaded files. Keys are slash separated paths, like
+// items/1/photo.jpg.
+type Storage interface {
+	// Put stores the file under the key, replacing what was there, and
+	// returns the URL it's served from.
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStorage makes the storage MEDIA_STORAGE names: "local", the default, or
+// "s3" for any S3-compatible service.
+func NewStorage() (Storage, error) {
+	switch os.Getenv("MEDIA_STORAGE") {
+	case "", "local":
+		return NewLocalStorage(), nil
+	case "s3":
+		return NewS3Storage()
+	}
+
+	return nil, errors.New("Error MEDIA_STORAGE has to be local or s3")
+}
+
+// LocalStorage keeps the files in the directory MEDIA_DIR, "media" by
+// default, which is served from MEDIA_URL, "/media" by default. A URL which
+// is a path is served by the API itself.
+type LocalStorage struct {
+	Dir string
+	URL string
+}
+
+func NewLocalStorage() *LocalStorage {
+	storage := &LocalStorage{Dir: os.Getenv("MEDIA_DIR"), URL: strings.TrimSuffix(os.Getenv("MEDIA_URL"), "/")}
+	if storage.Dir == "" {
+		storage.Dir = "media"
+	}
+
+	if storage.URL == "" {
+		storage.URL = "/media"
+	}
+
+	return storage
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	path := filepath.Clean(filepath.FromSlash(key))
+	if filepath.IsAbs(path) || path == "." || strings.HasPrefix(path, "..") {
+		return "", errors.New("Error invalid key of a file")
+	}
+
+	return filepath.Join(s.Dir, path), nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	// Writing to a temporary file first keeps a half written file from ever
+	// being served.
+	temporary := path + ".tmp"
+	if err = os.WriteFile(temporary, data, 0o644); err != nil {
+		return "", err
+	}
+
+	if err = os.Rename(temporary, path); err != nil {
+		os.Remove(temporary)
+		return "", err
+	}
+
+	return s.URL + "/" + key, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package privacy
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	. "github.com/Phantomvv1/E-commerce/internal/audit"
+	. "github.com/Phantomvv1/E-commerce/internal/authentication"
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+type exportSection struct {
+	Name  string
+	Query string
+}
+
+// Every query gets the id of the user as its only parameter and returns a
+// single json value. Secrets like password and token hashes are left out.
+var exportSections = []exportSection{
+	{"profile", "select to_jsonb(a) - 'password' - 'tokens_revoked_at' from e_commerce.authentication a where a.id = $1"},
+	{"cart", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id' order by t.id), '[]') from e_commerce.cart t where t.user_id = $1"},
+	{"wishlist", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id' order by t.id), '[]') from e_commerce.wishlist t where t.user_id = $1"},
+	{"comparison", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id'), '[]') from e_commerce.comparison t where t.user_id = $1"},
+	{"coupons", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id' order by t.id), '[]') from e_commerce.coupons t where t.user_id = $1"},
+	{"pointsHistory", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id' order by t.id), '[]') from e_commerce.points_history t where t.user_id = $1"},
+	{"roleChanges", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id' order by t.id), '[]') from e_commerce.role_changes t where t.user_id = $1"},
+	{"impersonations", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id' - 'jti' - 'ip' order by t.id), '[]') " +
+		"from e_commerce.impersonations t where t.user_id = $1"},
+	{"sessions", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id' order by t.id), '[]') from e_commerce.sessions t where t.user_id = $1"},
+	{"socialLogins", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id' order by t.id), '[]') from e_commerce.oauth_identities t where t.user_id = $1"},
+	{"twoFactor", "select coalesce(jsonb_agg(jsonb_build_object('enabled', t.enabled, 'createdAt', t.created_at)), '[]') " +
+		"from e_commerce.two_factor t where t.user_id = $1"},
+	{"emailVerifications", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id' - 'token_hash' order by t.id), '[]') " +
+		"from e_commerce.email_verifications t where t.user_id = $1"},
+	{"passwordResets", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id' - 'token_hash' order by t.id), '[]') " +
+		"from e_commerce.password_resets t where t.user_id = $1"},
+	{"loginLinks", "select coalesce(jsonb_agg(to_jsonb(t) - 'user_id' - 'token_hash' order by t.id), '[]') " +
+		"from e_commerce.login_links t where t.user_id = $1"},
+	{"sentEmails", "select coalesce(jsonb_agg(to_jsonb(t) order by t.id), '[]') from e_commerce.sent_emails t " +
+		"where t.recipient in (select email from e_commerce.authentication where id = $1 union select pending_email from e_commerce.authentication where id = $1)"},
+}
+
+type PrivacyHandler struct {
+	db    database.DB
+	users *AuthRepository
+}
+
+func NewPrivacyHandler(db database.DB) *PrivacyHandler {
+	return &PrivacyHandler{db: db, users: NewAuthRepository(db)}
+}
+
+func collectPersonalData(ctx context.Context, db database.DB, userID int) (map[string]json.RawMessage, error) {
+	data := map[string]json.RawMessage{}
+	for _, section := range exportSections {
+		var value []byte
+		err := db.QueryRow(ctx, section.Query, userID).Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+
+		data[section.Name] = value
+	}
+
+	return data, nil
+}
+
+func (h *PrivacyHandler) exportPersonalData(c *gin.Context, userID int) {
+	ctx := c.Request.Context()
+	exists, err := h.users.Exists(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		return
+	}
+
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no user with this id"})
+		return
+	}
+
+	var data map[string]json.RawMessage
+	err = database.InTx(ctx, h.db, func(tx pgx.Tx) error {
+		var err error
+		if data, err = collectPersonalData(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		return Record(c, tx, "privacy.export", "user", userID, nil, nil)
+	})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to collect the data of the user"})
+		return
+	}
+
+	exportedAt := time.Now().UTC()
+	if c.Query("format") != "zip" {
+		c.JSON(http.StatusOK, gin.H{"userID": userID, "exportedAt": exportedAt, "data": data})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"user-%d-%s.zip\"", userID, exportedAt.Format("20060102")))
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+	for _, section := range exportSections {
+		file, err := archive.Create(section.Name + ".json")
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		if _, err = file.Write(data[section.Name]); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	if err = archive.Close(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (h *PrivacyHandler) ExportPersonalData(c *gin.Context) {
+	h.exportPersonalData(c, CurrentUserID(c))
+}
+
+func (h *PrivacyHandler) ExportUserData(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the user"})
+		return
+	}
+
+	h.exportPersonalData(c, userID)
+}
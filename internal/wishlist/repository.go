@@ -0,0 +1,53 @@
+package wishlist
+
+import (
+	"context"
+
+	"github.com/Phantomvv1/E-commerce/internal/database"
+	. "github.com/Phantomvv1/E-commerce/internal/items"
+)
+
+type WishlistRepository struct {
+	db database.DB
+}
+
+func NewWishlistRepository(db database.DB) *WishlistRepository {
+	return &WishlistRepository{db: db}
+}
+
+func (r *WishlistRepository) Add(ctx context.Context, userID int, variant *Variant) error {
+	_, err := r.db.Exec(ctx, "insert into e_commerce.wishlist (user_id, item_id, variant_id) values ($1, $2, $3)", userID, variant.ItemID, variant.ID)
+	return err
+}
+
+// Get returns the variants of the item which are in the wishlist of the user.
+func (r *WishlistRepository) Get(ctx context.Context, userID, itemID int) ([]ItemVariant, error) {
+	rows, err := r.db.Query(ctx, "select i.id, i.name, i.description, i.price, "+VariantColumns+" from e_commerce.wishlist w "+
+		"join e_commerce.variants v on v.id = w.variant_id join e_commerce.items i on i.id = v.item_id where w.user_id = $1 and w.item_id = $2 order by w.id", userID, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ScanItemVariants(rows)
+}
+
+func (r *WishlistRepository) Items(ctx context.Context, userID int) ([]ItemVariant, error) {
+	rows, err := r.db.Query(ctx, "select i.id, i.name, i.description, i.price, "+VariantColumns+" from e_commerce.wishlist w "+
+		"join e_commerce.variants v on v.id = w.variant_id join e_commerce.items i on i.id = v.item_id where w.user_id = $1 order by w.id", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ScanItemVariants(rows)
+}
+
+// Remove takes the variant out of the wishlist, or every variant of the item
+// when variantID is nil.
+func (r *WishlistRepository) Remove(ctx context.Context, userID, itemID int, variantID *int) (bool, error) {
+	result, err := r.db.Exec(ctx, "delete from e_commerce.wishlist where user_id = $1 and item_id = $2 and ($3::int is null or variant_id = $3)", userID, itemID, variantID)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() > 0, nil
+}
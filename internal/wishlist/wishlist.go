@@ -1,116 +1,50 @@
 package wishlist
 
 import (
-	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 
 	. "github.com/Phantomvv1/E-commerce/internal/authentication"
+	"github.com/Phantomvv1/E-commerce/internal/database"
 	. "github.com/Phantomvv1/E-commerce/internal/items"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
 )
 
-func createWishlistTable(conn *pgx.Conn) error {
-	_, err := conn.Exec(context.Background(), "create table if not exists e_commerce.wishlist (id serial primary key, user_id int "+
-		"references e_commerce.authentication(id), item_id int references e_commerce.items(id))")
-
-	return err
+type WishlistHandler struct {
+	wishlists *WishlistRepository
+	items     *ItemRepository
 }
 
-func PutItemInWishlist(c *gin.Context) {
-	var information map[string]interface{}
-	json.NewDecoder(c.Request.Body).Decode(&information)
-
-	token, ok := information["token"].(string)
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
-
-	id, _, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
-		return
-	}
-
-	itemID, ok := information["itemID"].(float64)
-	if !ok {
-		log.Println("Incorrectly provided id of the item")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the item"})
-		return
-	}
-
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
-		return
-	}
-	defer conn.Close(context.Background())
-
-	if err = createWishlistTable(conn); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to create a table for the wishlist"})
-		return
-	}
-
-	_, err = conn.Exec(context.Background(), "insert into e_commerce.wishlist (user_id, item_id) values ($1, $2)", id, itemID)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put information in the database"})
-		return
-	}
-
-	c.JSON(http.StatusOK, nil)
+func NewWishlistHandler(db database.DB) *WishlistHandler {
+	return &WishlistHandler{wishlists: NewWishlistRepository(db), items: NewItemRepository(db)}
 }
 
-func GetItemFromWishlist(c *gin.Context) {
+func (h *WishlistHandler) PutItemInWishlist(c *gin.Context) {
 	var information map[string]interface{}
-	json.NewDecoder(c.Request.Body).Decode(&information)
+	json.NewDecoder(c.Request.Body).Decode(&information) // itemID && variantID?
 
-	token, ok := information["token"].(string)
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
-
-	id, _, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
-		return
-	}
+	id := CurrentUserID(c)
 
 	itemID, ok := information["itemID"].(float64)
 	if !ok {
 		log.Println("Incorrectly provided id of the item")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of teh item"})
-		return
-	}
-
-	item := Item{}
-	item.ID = int(itemID)
-
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the item"})
 		return
 	}
-	defer conn.Close(context.Background())
 
-	check := 0
-	err = conn.QueryRow(context.Background(), "select id from e_commerce.wishlist w where w.user_id = $1 and w.item_id = $2", id, item.ID).Scan(&check)
+	ctx := c.Request.Context()
+	variant, err := h.items.ResolveVariant(ctx, int(itemID), OptionalVariantID(information))
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no item with this id in your wishlist"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no item with this id"})
+			return
+		}
+
+		if err == ErrVariantRequired {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
@@ -119,134 +53,66 @@ func GetItemFromWishlist(c *gin.Context) {
 		return
 	}
 
-	err = conn.QueryRow(context.Background(), "select name, description, price from e_commerce.items where id = $1", item.ID).Scan(&item.Name, &item.Description, &item.Price)
-	if err != nil {
+	if err = h.wishlists.Add(ctx, id, variant); err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to put information in the database"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"item": item})
+	c.JSON(http.StatusOK, nil)
 }
 
-func GetAllItemsFromWishlist(c *gin.Context) {
-	var information map[string]string
-	json.NewDecoder(c.Request.Body).Decode(&information)
+func (h *WishlistHandler) GetItemFromWishlist(c *gin.Context) {
+	id := CurrentUserID(c)
 
-	token, ok := information["token"]
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
-
-	id, _, err := ValidateJWT(token)
+	itemID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Error invalid token"})
-		return
-	}
-
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided id of the item"})
 		return
 	}
-	defer conn.Close(context.Background())
 
-	rows, err := conn.Query(context.Background(), "select item_id from e_commerce.wishlist w where w.user_id = $1", id)
+	entries, err := h.wishlists.Get(c.Request.Context(), id, itemID)
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
 	}
 
-	var itemIDs []interface{}
-	for rows.Next() {
-		id := 0
-		err = rows.Scan(&id)
-		if err != nil {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
-			return
-		}
-
-		itemIDs = append(itemIDs, id)
-	}
-
-	if rows.Err() != nil {
-		log.Println(rows.Err())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while working with the informatin from the database"})
+	if len(entries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Error there is no item with this id in your wishlist"})
 		return
 	}
 
-	query := "select name, description, price from e_commerce.items i where id in ("
-	for i := range itemIDs {
-		if i == len(itemIDs)-1 {
-			query += "$" + fmt.Sprintf("%d", i+1)
-		} else {
-			query += "$" + fmt.Sprintf("%d", i+1) + ", "
-		}
+	variants := []Variant{}
+	for _, entry := range entries {
+		variants = append(variants, entry.Variant)
 	}
 
-	query += ")"
-
-	if query == "select name, description, price from e_commerce.items i where id in ()" {
-		c.JSON(http.StatusOK, gin.H{"message": "There are no items in your wishlist"})
-		return
-	}
+	c.JSON(http.StatusOK, gin.H{"item": entries[0].Item, "variants": variants})
+}
 
-	rows, err = conn.Query(context.Background(), query, itemIDs...)
+func (h *WishlistHandler) GetAllItemsFromWishlist(c *gin.Context) {
+	items, err := h.wishlists.Items(c.Request.Context(), CurrentUserID(c))
 	if err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to get information from the database"})
 		return
 	}
 
-	var items []Item
-	i := 0
-	for rows.Next() {
-		item := Item{}
-		err = rows.Scan(&item.Name, &item.Description, &item.Price)
-		if err != nil {
-			log.Println(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while collecting information from the database"})
-			return
-		}
-
-		item.ID = itemIDs[i].(int)
-		i++
-
-		items = append(items, item)
-	}
-
-	if rows.Err() != nil {
-		log.Println(rows.Err())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while working with the information from the database"})
+	if len(items) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "There are no items in your wishlist"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"items": items})
 }
 
-func RemoveItemFromWishlist(c *gin.Context) {
+func (h *WishlistHandler) RemoveItemFromWishlist(c *gin.Context) {
 	var information map[string]interface{}
-	json.NewDecoder(c.Request.Body).Decode(&information)
+	json.NewDecoder(c.Request.Body).Decode(&information) // itemID && variantID?
 
-	token, ok := information["token"].(string)
-	if !ok {
-		log.Println("Incorrectly provided token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error incorrectly provided token"})
-		return
-	}
-
-	id, _, err := ValidateJWT(token)
-	if err != nil {
-		log.Println(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Error invalid token"})
-		return
-	}
+	id := CurrentUserID(c)
 
 	itemIDFl, ok := information["itemID"].(float64)
 	if !ok {
@@ -256,24 +122,15 @@ func RemoveItemFromWishlist(c *gin.Context) {
 	}
 	itemID := int(itemIDFl)
 
-	conn, err := pgx.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+	removed, err := h.wishlists.Remove(c.Request.Context(), id, itemID, OptionalVariantID(information))
 	if err != nil {
 		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to connect to the database"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to delete the information from the database"})
 		return
 	}
-	defer conn.Close(context.Background())
-
-	check := 0
-	err = conn.QueryRow(context.Background(), "delete from e_commerce.wishlist where user_id = $1 and item_id = $2 returning id", id, itemID).Scan(&check)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			c.JSON(http.StatusNotExtended, gin.H{"error": "Error there is no item with this id in your wishlist"})
-			return
-		}
 
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error unable to delete the information from the database"})
+	if !removed {
+		c.JSON(http.StatusNotExtended, gin.H{"error": "Error there is no item with this id in your wishlist"})
 		return
 	}
 